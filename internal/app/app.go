@@ -2,89 +2,391 @@ package app
 
 import (
 	"context"
-	"fmt"
+	"github.com/da-semenov/go-short-url/internal/app/blocklist"
+	"github.com/da-semenov/go-short-url/internal/app/certs"
 	conf "github.com/da-semenov/go-short-url/internal/app/config"
+	"github.com/da-semenov/go-short-url/internal/app/grpcapi"
 	"github.com/da-semenov/go-short-url/internal/app/handlers"
+	"github.com/da-semenov/go-short-url/internal/app/health"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
+	"github.com/da-semenov/go-short-url/internal/app/metrics"
 	midlwr "github.com/da-semenov/go-short-url/internal/app/middleware"
+	"github.com/da-semenov/go-short-url/internal/app/ratelimit"
 	serv "github.com/da-semenov/go-short-url/internal/app/server"
 	"github.com/da-semenov/go-short-url/internal/app/storage"
+	"github.com/da-semenov/go-short-url/internal/app/templates"
+	"github.com/da-semenov/go-short-url/internal/app/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const (
+	rateClassRedirect = "redirect"
+	rateClassShorten  = "shorten"
+	rateClassBatch    = "batch"
+	rateClassDelete   = "delete"
+	rateClassPassword = "password"
+	rateClassQR       = "qr"
+)
+
+func rateLimits(config *conf.AppConfig) map[string]ratelimit.Limit {
+	limit := func(l conf.RateLimit) ratelimit.Limit {
+		return ratelimit.Limit{Rate: l.Rate, Burst: l.Burst}
+	}
+	return map[string]ratelimit.Limit{
+		rateClassRedirect: limit(config.RateLimitRedirect),
+		rateClassShorten:  limit(config.RateLimitShorten),
+		rateClassBatch:    limit(config.RateLimitBatch),
+		rateClassDelete:   limit(config.RateLimitDelete),
+		rateClassPassword: limit(config.RateLimitPassword),
+		rateClassQR:       limit(config.RateLimitQR),
+	}
+}
+
 func RunApp() {
 	config := conf.NewConfig()
 	err := config.Init()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if config.PrintConfig {
+		out, err := config.Redacted()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.WriteString(out)
+		return
+	}
+	lg, logLevel, err := logger.NewLogger(config.LogLevel)
+	if err != nil {
+		log.Fatal("can't init logger: ", err)
+	}
+	defer lg.Sync()
+
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:     config.TraceExporter,
+		FilePath:     config.TraceFile,
+		OTLPEndpoint: config.TraceOTLPEndpoint,
+		SampleRatio:  config.TraceSampleRatio,
+	})
+	if err != nil {
+		lg.Error("can't init tracing", zap.Error(err))
+		return
+	}
+	defer shutdownTracing(context.Background())
 
 	fileRepository, err := storage.NewFileStorage(config.FileStorage)
 	if err != nil {
-		fmt.Println("can't init file repository", err)
+		lg.Error("can't init file repository", zap.Error(err))
 		return
 	}
 
 	postgresHandler, err := storage.NewPostgresHandler(context.Background(), config.DatabaseDSN)
 	if err != nil {
-		fmt.Println("can't init postgres handler", err)
+		lg.Error("can't init postgres handler", zap.Error(err))
 		return
 	}
+	defer postgresHandler.Close()
 
 	if config.ReInit {
 		err = storage.ClearDatabase(context.Background(), postgresHandler)
 		if err != nil {
-			fmt.Println("can't clear database structure", err)
+			lg.Error("can't clear database structure", zap.Error(err))
 			return
 		}
 	}
 	err = storage.InitDatabase(context.Background(), postgresHandler)
 	if err != nil {
-		fmt.Println("can't init database structure", err)
+		lg.Error("can't init database structure", zap.Error(err))
 		return
 	}
+	lg.Info("database structure created successfully")
 
 	postgresRepository, err := storage.NewPostgresRepository(postgresHandler)
 	if err != nil {
-		fmt.Println("can't init postgres repository", err)
+		lg.Error("can't init postgres repository", zap.Error(err))
 		return
 	}
 
 	deleteRepository, err := storage.NewDeleteRepository(postgresHandler)
 	if err != nil {
-		fmt.Println("can't init delete repository", err)
+		lg.Error("can't init delete repository", zap.Error(err))
 		return
 	}
 
+	urlCache := storage.NewURLCache(config.URLCacheSize, config.URLCacheTTL, config.URLCacheNegativeTTL)
+	cachedRepository, err := storage.NewCachedRepository(postgresRepository, urlCache)
+	if err != nil {
+		lg.Error("can't init cached repository", zap.Error(err))
+		return
+	}
+	cachedDeleteRepository, err := storage.NewCachedDeleteRepository(deleteRepository, urlCache)
+	if err != nil {
+		lg.Error("can't init cached delete repository", zap.Error(err))
+		return
+	}
+	invalidationListener, err := storage.NewInvalidationListener(postgresHandler, urlCache, lg)
+	if err != nil {
+		lg.Error("can't init invalidation listener", zap.Error(err))
+		return
+	}
+	listenerCtx, stopListener := context.WithCancel(context.Background())
+	defer stopListener()
+	go invalidationListener.Run(listenerCtx)
+
 	cryptoService, err := serv.NewCryptoService()
 	if err != nil {
-		fmt.Println("error in crypto-service", err)
+		lg.Error("error in crypto-service", zap.Error(err))
+		return
+	}
+
+	blocklistRules, err := blocklist.LoadFile(config.BlocklistFile)
+	if err != nil {
+		lg.Error("can't load blocklist", zap.Error(err))
 		return
 	}
+	bl := blocklist.NewBlocklist(blocklistRules)
+	lg.Info("blocklist loaded", zap.Int("rules", bl.Len()))
+
+	userService := serv.NewUserService(cachedRepository, fileRepository, config.BaseURL, serv.Quotas{
+		MaxLinks:     config.MaxLinksPerUser,
+		MaxBatchSize: config.MaxBatchSize,
+	}, serv.URLPolicy{
+		AllowedSchemes: config.AllowedSchemes,
+		MaxLength:      config.MaxURLLength,
+		Normalize:      config.NormalizeURLs,
+		Blocklist:      bl,
+	}, lg)
+	clicksCtx, stopClicks := context.WithCancel(context.Background())
+	clicksDone := make(chan struct{})
+	go func() {
+		userService.RunClickFlusher(clicksCtx, config.ClickFlushInterval)
+		close(clicksDone)
+	}()
+	// the last clicks are flushed before the database connections are closed
+	defer func() {
+		stopClicks()
+		<-clicksDone
+	}()
+	if config.LinkAccessSecret != "" {
+		userService.EnablePasswordLinks()
+	}
+	deleteService := serv.NewDeleteService(cachedDeleteRepository, config.DeletePoolSize, config.DeleteTaskSize, config.MaxDeleteSize, lg)
+	pages, err := templates.Load(config.TemplatesDir)
+	if err != nil {
+		lg.Error("can't load templates", zap.Error(err))
+		return
+	}
+	uh := handlers.NewUserHandler(userService, cryptoService, deleteService, lg, handlers.Config{
+		CookieName:      config.TokenCookieName,
+		CookieMaxAge:    config.TokenCookieMaxAge,
+		CookieHTTPOnly:  config.TokenCookieHTTPOnly,
+		CookieSecure:    config.EnableHTTPS,
+		Templates:       pages,
+		BaseURL:         config.BaseURL,
+		QR:              config.QROptions(),
+		RedirectType:    config.RedirectType,
+		AccessMaxAge:    config.LinkAccessMaxAge,
+		AccessSecret:    []byte(config.LinkAccessSecret),
+		NotActiveStatus: config.LinkNotActiveStatus,
+		TrustedProxies:  config.TrustedProxyNets(),
+	})
+
+	reloader := conf.NewReloader(config)
+	reloader.OnReload(func(c conf.AppConfig) (func(), error) {
+		lvl, err := zapcore.ParseLevel(c.LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		return func() { logLevel.SetLevel(lvl) }, nil
+	})
+	reloader.OnReload(func(c conf.AppConfig) (func(), error) {
+		return func() { urlCache.Configure(c.URLCacheSize, c.URLCacheTTL, c.URLCacheNegativeTTL) }, nil
+	})
+	reloader.OnReload(func(c conf.AppConfig) (func(), error) {
+		rules, err := blocklist.LoadFile(c.BlocklistFile)
+		if err != nil {
+			return nil, err
+		}
+		return func() { bl.Set(rules) }, nil
+	})
+	rateStore := ratelimit.NewMemoryStore()
+	limiter := ratelimit.NewLimiter(rateStore, uh.ClientKeys, rateLimits(config))
+	uh.SetPasswordLimit(limiter, rateClassPassword)
+	uh.SetQRLimit(limiter, rateClassQR)
+	reloader.OnReload(func(c conf.AppConfig) (func(), error) {
+		return func() { limiter.SetLimits(rateLimits(&c)) }, nil
+	})
+	ah := handlers.NewAdminHandler(reloader, config.AdminToken, lg)
+
+	m := metrics.NewMetrics(prometheus.NewRegistry())
+	m.WatchDeleteService(deleteService)
+	m.WatchFileStorage(fileRepository)
+	m.WatchURLCache(urlCache)
+	m.WatchPool(postgresHandler)
+
+	hc := health.NewHealth()
+	hc.AddCheck("database", postgresHandler.Ping)
+	hc.AddCheck("migrations", func(ctx context.Context) error {
+		return storage.CheckDatabaseStructure(ctx, postgresHandler)
+	})
+	hc.AddCheck("file_storage", fileRepository.CheckWritable)
+	hc.AddCheck("delete_queue", deleteService.CheckQueue)
 
-	userService := serv.NewUserService(postgresRepository, fileRepository, config.BaseURL)
-	deleteService := serv.NewDeleteService(deleteRepository, config.DeletePoolSize, config.DeleteTaskSize)
-	uh := handlers.NewUserHandler(userService, cryptoService, deleteService)
 	router := chi.NewRouter()
 	router.Use(middleware.CleanPath)
-	router.Use(middleware.Logger)
+	router.Use(logger.RequestIDMiddleware)
+	router.Use(tracing.Middleware)
+	router.Use(logger.Middleware(lg))
+	router.Use(m.Middleware)
 	router.Use(middleware.Recoverer)
 	router.Use(midlwr.GzipHandle)
 	router.Route("/", func(r chi.Router) {
-		r.Get("/{id}", uh.GetMethodHandler)
+		r.With(limiter.Middleware(rateClassRedirect)).Get("/{id}", uh.GetMethodHandler)
+		r.Post("/{id}", uh.UnlockHandler)
 		r.Get("/api/user/urls", uh.GetUserURLsHandler)
+		r.Get("/api/user/urls/{id}/qr", uh.QRHandler)
+		r.With(limiter.Middleware(rateClassShorten)).Put("/api/user/urls/{id}", uh.UpdateLinkHandler)
 		r.Get("/ping", uh.PingHandler)
-		r.Post("/api/shorten", uh.PostShortenHandler)
-		r.Post("/api/shorten/batch", uh.PostShortenBatchHandler)
-		r.Delete("/api/user/urls", uh.AsyncDeleteHandler)
-		r.Post("/", uh.PostMethodHandler)
+		r.Get("/healthz", hc.LivenessHandler)
+		r.Get("/readyz", hc.ReadinessHandler)
+		r.Method(http.MethodGet, "/metrics", m.Handler())
+		r.Post("/api/admin/reload", ah.ReloadHandler)
+		r.With(midlwr.TrustedSubnet(config.TrustedSubnet, uh.ClientIP)).Get("/api/internal/stats", uh.StatsHandler)
+		r.With(limiter.Middleware(rateClassShorten)).Post("/api/shorten", uh.PostShortenHandler)
+		r.With(limiter.Middleware(rateClassBatch)).Post("/api/shorten/batch", uh.PostShortenBatchHandler)
+		r.With(limiter.Middleware(rateClassDelete)).Delete("/api/user/urls", uh.AsyncDeleteHandler)
+		r.With(limiter.Middleware(rateClassShorten)).Post("/", uh.PostMethodHandler)
 		r.Put("/", uh.DefaultHandler)
 		r.Patch("/", uh.DefaultHandler)
 		r.Delete("/", uh.DefaultHandler)
 	})
 
-	log.Println("starting server on 8080...")
-	log.Fatal(http.ListenAndServe(config.ServerAddress, router))
+	srv := &http.Server{Addr: config.ServerAddress, Handler: router}
+	if config.EnableHTTPS {
+		srv.TLSConfig, err = certs.TLSConfig(config.TLSCertFile, config.TLSKeyFile, baseHost(config.BaseURL))
+		if err != nil {
+			lg.Error("can't init TLS", zap.Error(err))
+			return
+		}
+		if config.TLSCertFile == "" {
+			lg.Warn("serving HTTPS with a self-signed certificate")
+		}
+	}
+	serverErr := make(chan error, 2)
+	var grpcServer *grpc.Server
+	var gs *grpcapi.Server
+	if config.GRPCAddress != "" {
+		lis, err := net.Listen("tcp", config.GRPCAddress)
+		if err != nil {
+			lg.Error("can't listen for gRPC", zap.Error(err))
+			return
+		}
+		var opts []grpc.ServerOption
+		if srv.TLSConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(srv.TLSConfig)))
+		}
+		gs = grpcapi.NewServer(userService, cryptoService, deleteService, lg)
+		gs.SetRateLimit(limiter, map[string]string{
+			"/shortener.v1.Shortener/Shorten":        rateClassShorten,
+			"/shortener.v1.Shortener/ShortenBatch":   rateClassBatch,
+			"/shortener.v1.Shortener/Resolve":        rateClassRedirect,
+			"/shortener.v1.Shortener/DeleteUserURLs": rateClassDelete,
+		})
+		grpcServer = gs.GRPCServer(opts...)
+		go func() {
+			serverErr <- grpcServer.Serve(lis)
+		}()
+		gs.SetServing(true)
+	}
+	// Bind before reporting ready, so /readyz never says yes while the
+	// address can't be served.
+	lis, err := net.Listen("tcp", config.ServerAddress)
+	if err != nil {
+		lg.Error("can't listen", zap.Error(err))
+		return
+	}
+	go func() {
+		if config.EnableHTTPS {
+			// The certificate is already in TLSConfig.
+			serverErr <- srv.ServeTLS(lis, "", "")
+			return
+		}
+		serverErr <- srv.Serve(lis)
+	}()
+	hc.SetReady(true)
+	lg.Info("starting server", zap.String("address", config.ServerAddress), zap.String("grpc_address", config.GRPCAddress), zap.String("base_url", config.BaseURL))
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			res, err := reloader.Reload()
+			if err != nil {
+				lg.Warn("config reload failed", zap.Error(err))
+				continue
+			}
+			lg.Info("config reloaded", zap.Strings("applied", res.Applied), zap.Strings("not_reloaded", res.NotReloaded))
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-serverErr:
+		lg.Error("server stopped", zap.Error(err))
+		return
+	case sig := <-stop:
+		lg.Info("shutting down", zap.String("signal", sig.String()))
+	}
+
+	// Fail readiness first and give load balancers time to notice before
+	// the listener and the database connections go away.
+	hc.SetReady(false)
+	if gs != nil {
+		gs.SetServing(false)
+	}
+	time.Sleep(config.ShutdownDrainDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if grpcServer != nil {
+		go func() {
+			<-ctx.Done()
+			grpcServer.Stop()
+		}()
+	}
+	err = srv.Shutdown(ctx)
+	if err != nil {
+		lg.Error("can't shut down server gracefully", zap.Error(err))
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	// Queued deletes still need the database, which is closed on return.
+	err = deleteService.Close(ctx)
+	if err != nil {
+		lg.Error("can't finish queued deletes", zap.Error(err))
+	}
+	lg.Info("server stopped")
+}
+
+func baseHost(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
 }
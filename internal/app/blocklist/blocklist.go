@@ -0,0 +1,122 @@
+package blocklist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+const patternPrefix = "re:"
+
+// Rules is a parsed blocklist. The file has one rule per line:
+//
+//	evil.example                    the domain and all of its subdomains
+//	re:^https?://[^/]+/login\.php   a regular expression matched against the whole URL
+//	# comment
+type Rules struct {
+	domains  map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func Parse(r io.Reader) (*Rules, error) {
+	rules := &Rules{domains: make(map[string]bool)}
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if strings.HasPrefix(s, patternPrefix) {
+			re, err := regexp.Compile(strings.TrimPrefix(s, patternPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			rules.patterns = append(rules.patterns, re)
+			continue
+		}
+		rules.domains[normalizeHost(s)] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// LoadFile reads the rules from path; an empty path gives no rules.
+func LoadFile(path string) (*Rules, error) {
+	if path == "" {
+		return &Rules{}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read blocklist: %w", err)
+	}
+	defer f.Close()
+	rules, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse blocklist %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func (r *Rules) Len() int {
+	return len(r.domains) + len(r.patterns)
+}
+
+func (r *Rules) match(rawURL string) (string, bool) {
+	if u, err := url.Parse(rawURL); err == nil {
+		host := normalizeHost(u.Hostname())
+		for host != "" {
+			if r.domains[host] {
+				return host, true
+			}
+			i := strings.IndexByte(host, '.')
+			if i < 0 {
+				break
+			}
+			host = host[i+1:]
+		}
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(rawURL) {
+			return patternPrefix + re.String(), true
+		}
+	}
+	return "", false
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Blocklist holds the current rules; Set swaps them atomically while
+// requests are being screened.
+type Blocklist struct {
+	rules atomic.Value
+}
+
+func NewBlocklist(rules *Rules) *Blocklist {
+	var b Blocklist
+	b.Set(rules)
+	return &b
+}
+
+func (b *Blocklist) Set(rules *Rules) {
+	b.rules.Store(rules)
+}
+
+func (b *Blocklist) Len() int {
+	return b.rules.Load().(*Rules).Len()
+}
+
+// Match returns the rule that blocks rawURL.
+func (b *Blocklist) Match(rawURL string) (string, bool) {
+	return b.rules.Load().(*Rules).match(rawURL)
+}
@@ -0,0 +1,63 @@
+package blocklist
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRules = `
+# phishing
+evil.example
+Bad.Example.
+re:^https?://[^/]+/wp-login\.php
+`
+
+func TestBlocklist_Match(t *testing.T) {
+	rules, err := Parse(strings.NewReader(testRules))
+	assert.NoError(t, err)
+	b := NewBlocklist(rules)
+
+	tests := []struct {
+		name     string
+		url      string
+		wantRule string
+		blocked  bool
+	}{
+		{name: "Test 1. Domain.", url: "https://evil.example/path", wantRule: "evil.example", blocked: true},
+		{name: "Test 2. Subdomain.", url: "http://login.EVIL.example:8080/", wantRule: "evil.example", blocked: true},
+		{name: "Test 3. Case and trailing dot in the file.", url: "http://bad.example./", wantRule: "bad.example", blocked: true},
+		{name: "Test 4. Pattern.", url: "http://shop.example/wp-login.php?x=1", wantRule: `re:^https?://[^/]+/wp-login\.php`, blocked: true},
+		{name: "Test 5. Look-alike domain.", url: "http://notevil.example/", blocked: false},
+		{name: "Test 6. Clean URL.", url: "https://example.com/", blocked: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, blocked := b.Match(tt.url)
+			assert.Equal(t, tt.blocked, blocked)
+			assert.Equal(t, tt.wantRule, rule)
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(testRules), 0644))
+
+	rules, err := LoadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, rules.Len())
+
+	rules, err = LoadFile("")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rules.Len())
+	_, blocked := NewBlocklist(rules).Match("https://evil.example/")
+	assert.False(t, blocked)
+
+	assert.NoError(t, os.WriteFile(path, []byte("re:(\n"), 0644))
+	_, err = LoadFile(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 1")
+}
@@ -0,0 +1,70 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfSigned(t *testing.T) {
+	cert, err := SelfSigned("short.example", "10.0.0.1")
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	for _, host := range []string{"localhost", "short.example", "10.0.0.1", "127.0.0.1"} {
+		_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: pool})
+		assert.NoError(t, err, host)
+	}
+	_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: "other.example", Roots: pool})
+	assert.Error(t, err)
+}
+
+func TestTLSConfig(t *testing.T) {
+	generated, err := SelfSigned()
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(generated.PrivateKey)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: generated.Certificate[0]}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{name: "Test 1. Certificate files.", certFile: certFile, keyFile: keyFile},
+		{name: "Test 2. Self-signed.", certFile: "", keyFile: ""},
+		{name: "Test 3. Missing files.", certFile: filepath.Join(dir, "none.pem"), keyFile: keyFile, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := TLSConfig(tt.certFile, tt.keyFile)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			srv.TLS = cfg
+			srv.StartTLS()
+			defer srv.Close()
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+			res, err := client.Get(srv.URL)
+			assert.NoError(t, err)
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		})
+	}
+}
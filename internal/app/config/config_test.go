@@ -0,0 +1,224 @@
+package config
+
+import (
+	"github.com/caarlos0/env/v6"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAppConfig_Precedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", "server_address: \":9000\"\nbase_url: http://file/\ndelete_pool_size: 7\nurl_cache_ttl: 1m\nlog_level: debug\n")
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		wantAddr   string
+		wantBase   string
+		wantPool   int
+		wantTTL    time.Duration
+		wantLogLvl string
+	}{
+		{name: "Test 1. File over defaults.",
+			args:       []string{"-c", path},
+			env:        map[string]string{},
+			wantAddr:   ":9000",
+			wantBase:   "http://file/",
+			wantPool:   7,
+			wantTTL:    time.Minute,
+			wantLogLvl: "debug",
+		},
+		{name: "Test 2. Env over file.",
+			args:       nil,
+			env:        map[string]string{"CONFIG": path, "BASE_URL": "http://env", "DELETE_POOL_SIZE": "3"},
+			wantAddr:   ":9000",
+			wantBase:   "http://env/",
+			wantPool:   3,
+			wantTTL:    time.Minute,
+			wantLogLvl: "debug",
+		},
+		{name: "Test 3. Flags over env.",
+			args:       []string{"--config", path, "-b", "http://flag/", "-l", "warn"},
+			env:        map[string]string{"BASE_URL": "http://env/", "LOG_LEVEL": "error"},
+			wantAddr:   ":9000",
+			wantBase:   "http://flag/",
+			wantPool:   7,
+			wantTTL:    time.Minute,
+			wantLogLvl: "warn",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConfig()
+			err := c.load("shortener", tt.args, env.Options{Environment: tt.env})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantAddr, c.ServerAddress)
+			assert.Equal(t, tt.wantBase, c.BaseURL)
+			assert.Equal(t, tt.wantPool, c.DeletePoolSize)
+			assert.Equal(t, tt.wantTTL, c.URLCacheTTL)
+			assert.Equal(t, tt.wantLogLvl, c.LogLevel)
+			assert.Equal(t, 500, c.DeleteTaskSize)
+			assert.Empty(t, c.GRPCAddress, "gRPC is off unless configured")
+		})
+	}
+}
+
+func TestAppConfig_JSONFile(t *testing.T) {
+	path := writeFile(t, "config.json", `{"base_url": "https://short.example", "url_cache_size": 5}`)
+	c := NewConfig()
+	err := c.load("shortener", []string{"-c", path}, env.Options{Environment: map[string]string{}})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://short.example/", c.BaseURL)
+	assert.Equal(t, 5, c.URLCacheSize)
+}
+
+func TestAppConfig_HTTPS(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		wantBase string
+	}{
+		{name: "Test 1. Default base URL switches to https.",
+			args:     []string{"-s"},
+			env:      map[string]string{},
+			wantBase: "https://localhost:8080/",
+		},
+		{name: "Test 2. Explicit base URL switches to https.",
+			env:      map[string]string{"ENABLE_HTTPS": "true", "BASE_URL": "http://short.example"},
+			wantBase: "https://short.example/",
+		},
+		{name: "Test 3. Plain HTTP keeps the base URL.",
+			env:      map[string]string{"BASE_URL": "http://short.example/"},
+			wantBase: "http://short.example/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConfig()
+			err := c.load("shortener", tt.args, env.Options{Environment: tt.env})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBase, c.BaseURL)
+		})
+	}
+}
+
+func TestAppConfig_RateLimits(t *testing.T) {
+	path := writeFile(t, "config.yaml", "rate_limit_shorten:\n  rate: 0.5\n  burst: 3\n")
+	c := NewConfig()
+	err := c.load("shortener", []string{"-c", path}, env.Options{Environment: map[string]string{"RATE_LIMIT_SHORTEN_BURST": "7"}})
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 0.5, Burst: 7}, c.RateLimitShorten)
+	assert.Equal(t, RateLimit{Rate: 1, Burst: 5}, c.RateLimitBatch)
+}
+
+func TestAppConfig_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr []string
+	}{
+		{name: "Test 1. Malformed base URL and pool size.",
+			env:     map[string]string{"BASE_URL": "localhost:8080", "DELETE_POOL_SIZE": "0"},
+			wantErr: []string{"base_url", "delete_pool_size must be positive"},
+		},
+		{name: "Test 2. Unknown key in file.",
+			file:    "base_ulr: http://typo/\n",
+			env:     map[string]string{},
+			wantErr: []string{"base_ulr"},
+		},
+		{name: "Test 3. Bad env value.",
+			env:     map[string]string{"URL_CACHE_TTL": "soon"},
+			wantErr: []string{"URLCacheTTL", "soon"},
+		},
+		{name: "Test 4. Rate limit without burst.",
+			env:     map[string]string{"RATE_LIMIT_BATCH_RATE": "3", "RATE_LIMIT_BATCH_BURST": "0"},
+			wantErr: []string{"rate_limit_batch"},
+		},
+		{name: "Test 5. Certificate without key.",
+			env:     map[string]string{"ENABLE_HTTPS": "true", "TLS_CERT_FILE": "cert.pem"},
+			wantErr: []string{"tls_cert_file and tls_key_file"},
+		},
+		{name: "Test 6. Unknown QR level.",
+			env:     map[string]string{"QR_LEVEL": "X"},
+			wantErr: []string{"qr: level must be one of L, M, Q, H"},
+		},
+		{name: "Test 7. Unsupported redirect type.",
+			env:     map[string]string{"REDIRECT_TYPE": "303"},
+			wantErr: []string{"redirect_type must be one of [301 302 307 308], got 303"},
+		},
+		{name: "Test 8. Redirect as the not active status.",
+			env:     map[string]string{"LINK_NOT_ACTIVE_STATUS": "302"},
+			wantErr: []string{"link_not_active_status must be a 2xx, 4xx or 5xx status, got 302"},
+		},
+		{name: "Test 9. Trusted proxy without a mask.",
+			env:     map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8,10.1.2.3"},
+			wantErr: []string{`trusted_proxies entry "10.1.2.3" must be a CIDR`},
+		},
+		{name: "Test 10. Short link access secret.",
+			env:     map[string]string{"LINK_ACCESS_SECRET": "short"},
+			wantErr: []string{"link_access_secret must be at least 32 bytes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.file != "" {
+				args = []string{"-c", writeFile(t, "config.yaml", tt.file)}
+			}
+			err := NewConfig().load("shortener", args, env.Options{Environment: tt.env})
+			assert.Error(t, err)
+			for _, want := range tt.wantErr {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestAppConfig_TrustedProxyNets(t *testing.T) {
+	c := NewConfig()
+	err := c.load("shortener", nil, env.Options{Environment: map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8,fd00::/8"}})
+	assert.NoError(t, err)
+	nets := c.TrustedProxyNets()
+	if assert.Len(t, nets, 2) {
+		assert.Equal(t, "10.0.0.0/8", nets[0].String())
+		assert.Equal(t, "fd00::/8", nets[1].String())
+	}
+	assert.Zero(t, c.RateLimitRedirect.Rate, "redirects are not limited by default")
+	assert.Positive(t, c.RateLimitQR.Rate, "qr codes are limited by default")
+}
+
+func TestAppConfig_Redacted(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+		want string
+	}{
+		{name: "Test 1. URL DSN.", dsn: "postgresql://user:hunter2@db:5432/mdb", want: "postgresql://user:[REDACTED]@db:5432/mdb"},
+		{name: "Test 2. Keyword DSN.", dsn: "host=db user=user password=hunter2", want: "host=db user=user password=[REDACTED]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			c.DatabaseDSN = tt.dsn
+			c.LinkAccessSecret = strings.Repeat("k", minSecretLength)
+			out, err := c.Redacted()
+			assert.NoError(t, err)
+			assert.False(t, strings.Contains(out, "hunter2"))
+			assert.False(t, strings.Contains(out, c.LinkAccessSecret))
+			assert.Contains(t, out, tt.want)
+		})
+	}
+}
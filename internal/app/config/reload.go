@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ReloadFunc prepares a reloadable setting from the new config. It returns
+// the function that switches the running service over; nothing is switched
+// until every ReloadFunc has succeeded.
+type ReloadFunc func(cfg AppConfig) (apply func(), err error)
+
+type ReloadResult struct {
+	Applied     []string `json:"applied"`
+	NotReloaded []string `json:"not_reloaded"`
+}
+
+// Reloader re-reads the config from the sources it was first loaded from and
+// applies the fields tagged reload:"true". Other changed fields are reported
+// as NotReloaded and keep their old value until restart.
+type Reloader struct {
+	sync.Mutex
+	current AppConfig
+	funcs   []ReloadFunc
+}
+
+func NewReloader(config *AppConfig) *Reloader {
+	var r Reloader
+	r.current = *config
+	return &r
+}
+
+func (r *Reloader) OnReload(fn ReloadFunc) {
+	r.Lock()
+	defer r.Unlock()
+	r.funcs = append(r.funcs, fn)
+}
+
+// Current returns the config with the reloaded settings applied.
+func (r *Reloader) Current() AppConfig {
+	r.Lock()
+	defer r.Unlock()
+	return r.current
+}
+
+func (r *Reloader) Reload() (ReloadResult, error) {
+	r.Lock()
+	src := r.current.source
+	r.Unlock()
+
+	var next AppConfig
+	if err := next.load(src.name, src.args, src.envOpts); err != nil {
+		return ReloadResult{}, err
+	}
+	return r.apply(next)
+}
+
+func (r *Reloader) apply(next AppConfig) (ReloadResult, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	result := ReloadResult{Applied: []string{}, NotReloaded: []string{}}
+	updated := r.current
+	cur := reflect.ValueOf(&updated).Elem()
+	nxt := reflect.ValueOf(next)
+	for i := 0; i < cur.NumField(); i++ {
+		field := cur.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if field.PkgPath != "" || name == "-" || name == "" {
+			continue
+		}
+		if reflect.DeepEqual(cur.Field(i).Interface(), nxt.Field(i).Interface()) {
+			continue
+		}
+		if field.Tag.Get("reload") != "true" {
+			result.NotReloaded = append(result.NotReloaded, name)
+			continue
+		}
+		cur.Field(i).Set(nxt.Field(i))
+		result.Applied = append(result.Applied, name)
+	}
+
+	// ReloadFuncs run even when no field has changed, so settings kept in
+	// files, like the blocklist, are re-read
+	applies := make([]func(), 0, len(r.funcs))
+	for _, fn := range r.funcs {
+		apply, err := fn(updated)
+		if err != nil {
+			return ReloadResult{}, fmt.Errorf("can't reload config: %w", err)
+		}
+		if apply != nil {
+			applies = append(applies, apply)
+		}
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	r.current = updated
+	return result, nil
+}
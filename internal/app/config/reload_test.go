@@ -0,0 +1,77 @@
+package config
+
+import (
+	"errors"
+	"github.com/caarlos0/env/v6"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReloader_Reload(t *testing.T) {
+	path := writeFile(t, "config.yaml", "server_address: \":9000\"\nlog_level: info\nurl_cache_size: 10\n")
+	c := NewConfig()
+	err := c.load("shortener", []string{"-c", path}, env.Options{Environment: map[string]string{}})
+	assert.NoError(t, err)
+
+	r := NewReloader(c)
+	var applied AppConfig
+	r.OnReload(func(cfg AppConfig) (func(), error) {
+		return func() { applied = cfg }, nil
+	})
+
+	err = os.WriteFile(path, []byte("server_address: \":9001\"\nlog_level: debug\nurl_cache_size: 20\nurl_cache_ttl: 1m\n"), 0644)
+	assert.NoError(t, err)
+	res, err := r.Reload()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"log_level", "url_cache_size", "url_cache_ttl"}, res.Applied)
+	assert.Equal(t, []string{"server_address"}, res.NotReloaded)
+	assert.Equal(t, "debug", applied.LogLevel)
+	assert.Equal(t, 20, applied.URLCacheSize)
+	assert.Equal(t, time.Minute, applied.URLCacheTTL)
+	assert.Equal(t, ":9000", applied.ServerAddress, "static settings must keep the value they were started with")
+	assert.Equal(t, "debug", r.Current().LogLevel)
+
+	applied = AppConfig{}
+	res, err = r.Reload()
+	assert.NoError(t, err)
+	assert.Empty(t, res.Applied)
+	assert.Equal(t, "debug", applied.LogLevel, "reload funcs must run even if nothing changed")
+}
+
+func TestReloader_ReloadFails(t *testing.T) {
+	path := writeFile(t, "config.yaml", "log_level: info\n")
+	c := NewConfig()
+	err := c.load("shortener", []string{"-c", path}, env.Options{Environment: map[string]string{}})
+	assert.NoError(t, err)
+
+	r := NewReloader(c)
+	applies := 0
+	r.OnReload(func(cfg AppConfig) (func(), error) {
+		return func() { applies++ }, nil
+	})
+	r.OnReload(func(cfg AppConfig) (func(), error) {
+		if cfg.URLCacheSize == 13 {
+			return nil, errors.New("unlucky")
+		}
+		return nil, nil
+	})
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "Test 1. Invalid config.", content: "log_level: loud\n"},
+		{name: "Test 2. Rejected by a service.", content: "log_level: debug\nurl_cache_size: 13\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, os.WriteFile(path, []byte(tt.content), 0644))
+			_, err := r.Reload()
+			assert.Error(t, err)
+			assert.Equal(t, 0, applies, "nothing must be applied when a reload fails")
+			assert.Equal(t, "info", r.Current().LogLevel)
+		})
+	}
+}
@@ -1,12 +1,45 @@
 package database
 
-const InsertURL = "with first_insert as (insert into urls(id, correlation_id, original_url,short_url) values(nextval('seq_urls'), $2,$3,$4) RETURNING id\n)" +
-	"insert into user_urls (url_id, user_id) select id, $1 from first_insert"
+const InsertURL = "insert into urls(id, correlation_id, original_url, short_url, title, redirect_type, query_passthrough, utm_source, utm_medium, utm_campaign, utm_conflict, password_hash, clicks_left, active_from, active_until, rules, own_code) " +
+	"values(nextval('seq_urls'), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) returning id"
+
+const InsertUserURL = "insert into user_urls (url_id, user_id) values ($1, $2)"
 
 const GetURLsByUserID = "select id, user_id, original_url, short_url from urls t1, user_urls t2 where t1.id=t2.url_id and t2.user_id=$1"
 
-const GetOriginalURLByShort = "select original_url from urls t1, user_urls t2 where t1.id =t2.url_id and t2.is_deleted=0 and t1.short_url=$1"
+// links that have used up their clicks are treated as deleted
+const liveLink = "(t1.clicks_left is null or t1.clicks_left>0)"
+
+const GetOriginalURLByShort = "select original_url from urls t1, user_urls t2 where t1.id =t2.url_id and t2.is_deleted=0 and t1.short_url=$1 and " + liveLink
+
+const GetOriginalURLByShortForUser = "select original_url from urls t1, user_urls t2 where t1.id=t2.url_id and t2.is_deleted=0 and t2.user_id=$1 and t1.short_url =$2 and " + liveLink
+
+const GetLinkByShort = "select original_url, coalesce(title, ''), created_at, clicks, password_hash, coalesce(clicks_left, 0), active_from, active_until from urls t1, user_urls t2 where t1.id=t2.url_id and t2.is_deleted=0 and t1.short_url=$1 and " + liveLink
+
+const GetTargetByShort = "select original_url, redirect_type, query_passthrough, utm_source, utm_medium, utm_campaign, utm_conflict, password_hash, clicks_left is not null, active_from, active_until, coalesce(rules::text, '') from urls t1, user_urls t2 where t1.id=t2.url_id and t2.is_deleted=0 and t1.short_url=$1 and " + liveLink
+
+// LockLink locks a link of the user for UpdateLink and tells whether it has
+// a code of its own.
+const LockLink = "select t1.own_code from urls t1, user_urls t2 where t1.id=t2.url_id and t2.is_deleted=0 and t2.user_id=$1 and t1.short_url=$2 for update of t1"
+
+const UpdateLink = "update urls t1 set title=$3, redirect_type=$4, query_passthrough=$5, utm_source=$6, utm_medium=$7, utm_campaign=$8, utm_conflict=$9, password_hash=coalesce($10, password_hash), clicks_left=case when $15 then clicks_left else $11 end, active_from=$12, active_until=$13, rules=$14 from user_urls t2 where t1.id=t2.url_id and t2.is_deleted=0 and t2.user_id=$1 and t1.short_url=$2 returning t1.id"
 
-const GetOriginalURLByShortForUser = "select original_url from urls t1, user_urls t2 where t1.id=t2.url_id and t2.is_deleted=0 and t2.user_id=$1 and t1.short_url =$2"
+const AddClicks = "update urls set clicks=clicks+$2 where short_url=$1"
+
+// TakeClick counts a click of a click-limited link; concurrent updates of the
+// row are serialized, so clicks_left never goes below zero.
+const TakeClick = "update urls t1 set clicks_left=clicks_left-1, clicks=clicks+1 from user_urls t2 where t1.id=t2.url_id and t2.is_deleted=0 and t1.short_url=$1 and t1.clicks_left>0 returning t1.clicks_left"
 
 const DeleteUserURL = "update user_urls t1 set is_deleted=1 from urls t2 where t1.url_id=t2.id and t1.user_id=$1 and t2.short_url=$2"
+
+const CountURLs = "select count(*) from urls t1, user_urls t2 where t1.id=t2.url_id and t2.is_deleted=0"
+
+const CountUserURLs = "select count(*) from user_urls where user_id=$1 and is_deleted=0"
+
+const CountUsers = "select count(distinct user_id) from user_urls"
+
+const InvalidationChannel = "url_invalidation"
+
+const ListenInvalidation = "listen " + InvalidationChannel
+
+const NotifyInvalidation = "select pg_notify('" + InvalidationChannel + "', $1)"
@@ -3,7 +3,24 @@ package database
 const urls = "create table if not exists  urls (id numeric primary key, correlation_id varchar, original_url varchar, short_url varchar);\n" +
 	"create sequence if not exists seq_urls increment by 1 no minvalue no maxvalue start with 1 cache 10 owned by urls.id;\n" +
 	"create index if not exists urls_short_url_idx on urls (short_url);\n" +
-	"create unique index if not exists urls_udx on urls (original_url);\n"
+	"alter table urls add column if not exists title varchar;\n" +
+	"alter table urls add column if not exists created_at timestamptz not null default now();\n" +
+	"alter table urls add column if not exists clicks bigint not null default 0;\n" +
+	"alter table urls add column if not exists redirect_type integer not null default 0;\n" +
+	"alter table urls add column if not exists query_passthrough boolean not null default false;\n" +
+	"alter table urls add column if not exists utm_source varchar not null default '';\n" +
+	"alter table urls add column if not exists utm_medium varchar not null default '';\n" +
+	"alter table urls add column if not exists utm_campaign varchar not null default '';\n" +
+	"alter table urls add column if not exists utm_conflict varchar not null default '';\n" +
+	"alter table urls add column if not exists password_hash varchar not null default '';\n" +
+	"alter table urls add column if not exists clicks_left bigint;\n" +
+	"alter table urls add column if not exists active_from timestamptz;\n" +
+	"alter table urls add column if not exists active_until timestamptz;\n" +
+	"alter table urls add column if not exists rules jsonb;\n" +
+	"alter table urls add column if not exists own_code boolean not null default false;\n" +
+	// only plain links are one per URL; links with options have codes of their own
+	"drop index if exists urls_udx;\n" +
+	"create unique index if not exists urls_plain_udx on urls (original_url) where not own_code;\n"
 
 const userURLs = "create table if not exists  user_urls (user_id varchar, url_id numeric, is_deleted numeric default 0);\n" +
 	"create unique index if not exists user_url_idx1 on user_urls (user_id, url_id);\n"
@@ -11,3 +28,22 @@ const userURLs = "create table if not exists  user_urls (user_id varchar, url_id
 const CreateDatabaseStructure = urls + userURLs
 
 const ClearDatabaseStructure = "drop table if exists user_urls cascade; drop table if exists urls cascade;"
+
+const CountDatabaseTables = "select count(*) from information_schema.tables where table_schema=current_schema() and table_name in ('urls', 'user_urls')"
+
+const DatabaseTablesCount = 2
+
+// URLColumns are the columns urls has gained since it was created; a
+// database without one of them was not migrated.
+var URLColumns = []string{"title", "created_at", "clicks", "redirect_type", "query_passthrough",
+	"utm_source", "utm_medium", "utm_campaign", "utm_conflict", "password_hash",
+	"clicks_left", "active_from", "active_until", "rules", "own_code"}
+
+// DatabaseIndexes are the indexes the queries rely on.
+var DatabaseIndexes = []string{"urls_short_url_idx", "urls_plain_udx", "user_url_idx1"}
+
+const MissingURLColumns = "select array(select c from unnest($1::text[]) c where c not in " +
+	"(select column_name::text from information_schema.columns where table_schema=current_schema() and table_name='urls'))"
+
+const MissingIndexes = "select array(select i from unnest($1::text[]) i where i not in " +
+	"(select indexname::text from pg_indexes where schemaname=current_schema()))"
@@ -0,0 +1,298 @@
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative -I pb pb/shortener.proto
+
+import (
+	"context"
+	"errors"
+	"github.com/da-semenov/go-short-url/internal/app/grpcapi/pb"
+	"github.com/da-semenov/go-short-url/internal/app/handlers"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
+	"github.com/da-semenov/go-short-url/internal/app/ratelimit"
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"time"
+)
+
+// TokenMetadataKey carries the user token, like the token cookie does over HTTP.
+const TokenMetadataKey = "token"
+
+// Methods that don't act on behalf of a user and so don't need a token.
+var publicMethods = map[string]bool{
+	"/shortener.v1.Shortener/Resolve": true,
+	"/shortener.v1.Shortener/Ping":    true,
+	"/grpc.health.v1.Health/Check":    true,
+	"/grpc.health.v1.Health/Watch":    true,
+}
+
+type userIDKey struct{}
+
+// RateLimiter counts calls per client and class, as ratelimit.Limiter does.
+type RateLimiter interface {
+	Take(class string, keys ...string) (bool, time.Duration)
+}
+
+// Server implements the gRPC API on top of the same services as the HTTP handlers.
+type Server struct {
+	pb.UnimplementedShortenerServer
+	userService   handlers.UserService
+	cryptoService handlers.CryptoService
+	deleteService handlers.DeleteService
+	health        *health.Server
+	log           *zap.Logger
+	limiter       RateLimiter
+	rateClasses   map[string]string
+}
+
+func NewServer(us handlers.UserService, cs handlers.CryptoService, ds handlers.DeleteService, log *zap.Logger) *Server {
+	var s Server
+	s.userService = us
+	s.cryptoService = cs
+	s.deleteService = ds
+	s.health = health.NewServer()
+	s.log = log
+	return &s
+}
+
+// SetRateLimit limits calls with the classes of the HTTP routes. classes maps
+// full method names to limiter classes; other methods are not limited. It
+// must be called before GRPCServer.
+func (s *Server) SetRateLimit(limiter RateLimiter, classes map[string]string) {
+	s.limiter = limiter
+	s.rateClasses = classes
+}
+
+// GRPCServer returns a gRPC server with the shortener and the health services registered.
+func (s *Server) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(s.logInterceptor, s.rateInterceptor, s.authInterceptor))
+	srv := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(srv, s)
+	healthpb.RegisterHealthServer(srv, s.health)
+	return srv
+}
+
+// SetServing switches the health status of the whole server.
+func (s *Server) SetServing(serving bool) {
+	st := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		st = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus("", st)
+	s.health.SetServingStatus(pb.Shortener_ServiceDesc.ServiceName, st)
+}
+
+func (s *Server) logInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(logger.RequestIDHeader)) > 0 {
+		id = md.Get(logger.RequestIDHeader)[0]
+	}
+	if id == "" || len(id) > 64 {
+		id = logger.NewRequestID()
+	}
+	ctx = logger.WithRequestID(ctx, id)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	logger.For(ctx, s.log).Info("grpc request",
+		zap.String("method", info.FullMethod),
+		zap.String("code", status.Code(err).String()),
+		zap.Duration("duration", time.Since(start)),
+	)
+	return resp, err
+}
+
+// rateInterceptor answers ResourceExhausted with a retry-after header once
+// the client has used up the limit of the method.
+func (s *Server) rateInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	class, limited := s.rateClasses[info.FullMethod]
+	if s.limiter == nil || !limited {
+		return handler(ctx, req)
+	}
+	ok, retryAfter := s.limiter.Take(class, s.clientKeys(ctx)...)
+	if !ok {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", ratelimit.RetryAfter(retryAfter)))
+		return nil, status.Error(codes.ResourceExhausted, "too many requests")
+	}
+	return handler(ctx, req)
+}
+
+// clientKeys identifies the client like handlers.ClientKeys does: the peer
+// IP and, with a valid token, the user too.
+func (s *Server) clientKeys(ctx context.Context) []string {
+	var keys []string
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		keys = append(keys, "ip:"+host)
+	}
+	if md, exists := metadata.FromIncomingContext(ctx); exists && len(md.Get(TokenMetadataKey)) > 0 {
+		if ok, userID := s.cryptoService.Validate(md.Get(TokenMetadataKey)[0]); ok {
+			keys = append(keys, "user:"+userID)
+		}
+	}
+	return keys
+}
+
+// authInterceptor resolves the user from the token metadata and issues a new
+// token in the response header when there is no valid one.
+func (s *Server) authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	var userID string
+	var ok bool
+	if md, exists := metadata.FromIncomingContext(ctx); exists && len(md.Get(TokenMetadataKey)) > 0 {
+		ok, userID = s.cryptoService.Validate(md.Get(TokenMetadataKey)[0])
+	}
+	if !ok {
+		var token string
+		var err error
+		userID, token, err = s.cryptoService.GetNewUserToken()
+		if err != nil {
+			logger.For(ctx, s.log).Error("can't issue token", zap.Error(err))
+			return nil, status.Error(codes.Internal, "can't issue token")
+		}
+		if err = grpc.SetHeader(ctx, metadata.Pairs(TokenMetadataKey, token)); err != nil {
+			return nil, err
+		}
+	}
+	return handler(context.WithValue(ctx, userIDKey{}, userID), req)
+}
+
+func userIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(userIDKey{}).(string)
+	return id
+}
+
+// errorStatus converts a service error without a specific code; unexpected
+// errors are logged and hidden from the client.
+func (s *Server) errorStatus(ctx context.Context, err error) error {
+	var quotaErr *urls.QuotaError
+	if errors.As(err, &quotaErr) {
+		return status.Error(codes.ResourceExhausted, quotaErr.Error())
+	}
+	var validationErr *urls.ValidationError
+	if errors.As(err, &validationErr) {
+		return status.Error(codes.InvalidArgument, validationErr.Error())
+	}
+	var blockedErr *urls.BlockedError
+	if errors.As(err, &blockedErr) {
+		return status.Error(codes.PermissionDenied, blockedErr.Error())
+	}
+	var notActiveErr *urls.NotActiveError
+	if errors.As(err, &notActiveErr) {
+		return status.Error(codes.FailedPrecondition, notActiveErr.Error())
+	}
+	logger.For(ctx, s.log).Error("grpc request failed", zap.Error(err))
+	return status.Error(codes.Internal, "internal error")
+}
+
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	if req.Url == "" {
+		return nil, status.Error(codes.InvalidArgument, "url can't be empty")
+	}
+	opts := urls.LinkOptions{Title: req.Title}
+	resURL, key, err := s.userService.GetID(req.Url, opts)
+	if err != nil {
+		return nil, s.errorStatus(ctx, err)
+	}
+	resp := &pb.ShortenResponse{Result: resURL}
+	err = s.userService.SaveUserURL(ctx, userIDFrom(ctx), req.Url, key, opts)
+	if errors.Is(err, urls.ErrDuplicateKey) {
+		st, detailErr := status.New(codes.AlreadyExists, "url is already shortened").WithDetails(resp)
+		if detailErr != nil {
+			return nil, s.errorStatus(ctx, detailErr)
+		}
+		return nil, st.Err()
+	}
+	if err != nil {
+		return nil, s.errorStatus(ctx, err)
+	}
+	return resp, nil
+}
+
+func (s *Server) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	batch := make([]urls.UserBatch, 0, len(req.Urls))
+	for _, u := range req.Urls {
+		batch = append(batch, urls.UserBatch{CorrelationID: u.CorrelationId, OriginalURL: u.OriginalUrl, LinkOptions: urls.LinkOptions{Title: u.Title}})
+	}
+	res, err := s.userService.SaveBatch(ctx, userIDFrom(ctx), batch)
+	if errors.Is(err, urls.ErrDuplicateKey) {
+		return nil, status.Error(codes.AlreadyExists, "url is already shortened")
+	}
+	if err != nil {
+		return nil, s.errorStatus(ctx, err)
+	}
+	resp := &pb.ShortenBatchResponse{Urls: make([]*pb.BatchResult, 0, len(res))}
+	for _, r := range res {
+		resp.Urls = append(resp.Urls, &pb.BatchResult{CorrelationId: r.CorrelationID, ShortUrl: r.ShortURL})
+	}
+	return resp, nil
+}
+
+func (s *Server) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id can't be empty")
+	}
+	res, err := s.userService.Resolve(ctx, req.Id)
+	if errors.Is(err, urls.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "url was not found")
+	}
+	if err != nil {
+		return nil, s.errorStatus(ctx, err)
+	}
+	if res.PasswordHash != "" {
+		return nil, status.Error(codes.PermissionDenied, "link is password protected")
+	}
+	// the destination of a click-limited link is only given out for a click
+	if res.Limited {
+		err = s.userService.TakeClick(ctx, req.Id)
+		if errors.Is(err, urls.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "url was not found")
+		}
+		if err != nil {
+			return nil, s.errorStatus(ctx, err)
+		}
+	} else {
+		s.userService.RecordClick(ctx, req.Id)
+	}
+	return &pb.ResolveResponse{OriginalUrl: res.URL}, nil
+}
+
+func (s *Server) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	res, err := s.userService.GetURLsByUser(ctx, userIDFrom(ctx))
+	if err != nil {
+		return nil, s.errorStatus(ctx, err)
+	}
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, 0, len(res))}
+	for _, u := range res {
+		resp.Urls = append(resp.Urls, &pb.UserURL{ShortUrl: u.ShortURL, OriginalUrl: u.OriginalURL})
+	}
+	return resp, nil
+}
+
+// DeleteUserURLs queues the deletion like the HTTP API does; it returns
+// before the links are actually deleted.
+func (s *Server) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	err := s.deleteService.DeleteBatch(ctx, userIDFrom(ctx), req.Ids)
+	if err != nil {
+		return nil, s.errorStatus(ctx, err)
+	}
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+func (s *Server) Ping(ctx context.Context, req *pb.PingRequest) (*pb.PingResponse, error) {
+	if !s.userService.Ping(ctx) {
+		return nil, status.Error(codes.Unavailable, "storage is unavailable")
+	}
+	return &pb.PingResponse{}, nil
+}
@@ -0,0 +1,208 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"github.com/da-semenov/go-short-url/internal/app/grpcapi/pb"
+	"github.com/da-semenov/go-short-url/internal/app/handlers"
+	"github.com/da-semenov/go-short-url/internal/app/ratelimit"
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, setup ...func(s *Server)) (pb.ShortenerClient, healthpb.HealthClient, *handlers.DeleteServiceMock) {
+	userService := new(handlers.UserServiceMock)
+	userService.On("GetID", "original_URL").Return("http://localhost/short_URL", "short_URL", nil)
+	userService.On("GetID", "bad_URL").Return("http://localhost/short_URL", "short_URL", nil)
+	userService.On("SaveUserURL", "user_id", "original_URL", "short_URL").Return(nil)
+	userService.On("SaveUserURL", "new_user_id", "original_URL", "short_URL").Return(nil)
+	userService.On("SaveUserURL", "user_id", "bad_URL", "short_URL").Return(urls.ErrDuplicateKey)
+	userService.On("Resolve", "short_URL").Return(&urls.Target{URL: "original_URL"}, nil)
+	userService.On("Resolve", "badURL").Return(nil, urls.ErrNotFound)
+	userService.On("Resolve", "blocked_URL").Return(nil, &urls.BlockedError{URL: "https://evil.example/", Rule: "evil.example"})
+	userService.On("Resolve", "limited_URL").Return(&urls.Target{URL: "original_URL", Limited: true}, nil)
+	userService.On("Resolve", "used_URL").Return(&urls.Target{URL: "original_URL", Limited: true}, nil)
+	userService.On("RecordClick", "short_URL").Return()
+	userService.On("TakeClick", "limited_URL").Return(nil)
+	userService.On("TakeClick", "used_URL").Return(urls.ErrNotFound)
+	userService.On("Resolve", "early_URL").Return(nil, &urls.NotActiveError{ActiveFrom: time.Now().Add(time.Hour)})
+	userService.On("Resolve", "protected_URL").Return(&urls.Target{URL: "original_URL", PasswordHash: "hash"}, nil)
+	userService.On("GetURLsByUser", "user_id").Return("url-for-user", nil)
+	userService.On("SaveBatch", "user_id", []urls.UserBatch{{CorrelationID: "c1", OriginalURL: "original_URL"}}).Return("c1", "short_URL", nil)
+	userService.On("Ping").Return(true)
+
+	cryptoService := new(handlers.CryptoServiceMock)
+	cryptoService.On("Validate", "user_id").Return(true)
+	cryptoService.On("Validate", mock.Anything).Return(false)
+	cryptoService.On("GetNewUserToken").Return("new_user_id", "new_token", nil)
+
+	deleteService := new(handlers.DeleteServiceMock)
+	deleteService.On("DeleteBatch", "user_id", []string{"short_URL"}).Return(nil)
+	deleteService.On("DeleteBatch", "user_id", []string{"broken"}).Return(errors.New("queue is full"))
+
+	s := NewServer(userService, cryptoService, deleteService, zap.NewNop())
+	for _, f := range setup {
+		f(s)
+	}
+	srv := s.GRPCServer()
+	s.SetServing(true)
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewShortenerClient(conn), healthpb.NewHealthClient(conn), deleteService
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), TokenMetadataKey, token)
+}
+
+func TestServer_Shorten(t *testing.T) {
+	client, _, _ := newTestClient(t)
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		url       string
+		wantCode  codes.Code
+		wantToken string
+	}{
+		{name: "Test 1. Shorten with token.", ctx: withToken("user_id"), url: "original_URL", wantCode: codes.OK},
+		{name: "Test 2. Shorten issues a token.", ctx: context.Background(), url: "original_URL", wantCode: codes.OK, wantToken: "new_token"},
+		{name: "Test 3. Duplicate.", ctx: withToken("user_id"), url: "bad_URL", wantCode: codes.AlreadyExists},
+		{name: "Test 4. Empty URL.", ctx: withToken("user_id"), url: "", wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header metadata.MD
+			res, err := client.Shorten(tt.ctx, &pb.ShortenRequest{Url: tt.url}, grpc.Header(&header))
+			st := status.Convert(err)
+			assert.Equal(t, tt.wantCode, st.Code())
+			switch tt.wantCode {
+			case codes.OK:
+				assert.Equal(t, "http://localhost/short_URL", res.Result)
+			case codes.AlreadyExists:
+				if assert.Len(t, st.Details(), 1) {
+					assert.Equal(t, "http://localhost/short_URL", st.Details()[0].(*pb.ShortenResponse).Result)
+				}
+			}
+			if tt.wantToken != "" {
+				assert.Equal(t, []string{tt.wantToken}, header.Get(TokenMetadataKey))
+			}
+		})
+	}
+}
+
+func TestServer_Resolve(t *testing.T) {
+	var userService *handlers.UserServiceMock
+	client, _, _ := newTestClient(t, func(s *Server) {
+		userService = s.userService.(*handlers.UserServiceMock)
+	})
+	tests := []struct {
+		name     string
+		id       string
+		wantCode codes.Code
+		wantURL  string
+	}{
+		{name: "Test 1. Resolve.", id: "short_URL", wantCode: codes.OK, wantURL: "original_URL"},
+		{name: "Test 2. Unknown id.", id: "badURL", wantCode: codes.NotFound},
+		{name: "Test 3. Empty id.", id: "", wantCode: codes.InvalidArgument},
+		{name: "Test 4. Blocklisted destination.", id: "blocked_URL", wantCode: codes.PermissionDenied},
+		{name: "Test 5. Password-protected link.", id: "protected_URL", wantCode: codes.PermissionDenied},
+		{name: "Test 6. Click-limited link.", id: "limited_URL", wantCode: codes.OK, wantURL: "original_URL"},
+		{name: "Test 7. No clicks left.", id: "used_URL", wantCode: codes.NotFound},
+		{name: "Test 8. Not active yet.", id: "early_URL", wantCode: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := client.Resolve(context.Background(), &pb.ResolveRequest{Id: tt.id})
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			if tt.wantURL != "" {
+				assert.Equal(t, tt.wantURL, res.OriginalUrl)
+			}
+		})
+	}
+	userService.AssertCalled(t, "RecordClick", "short_URL")
+	userService.AssertNotCalled(t, "RecordClick", "limited_URL")
+}
+
+func TestServer_UserURLs(t *testing.T) {
+	client, _, deleteService := newTestClient(t)
+	ctx := withToken("user_id")
+
+	batch, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Urls: []*pb.BatchURL{{CorrelationId: "c1", OriginalUrl: "original_URL"}}})
+	assert.NoError(t, err)
+	if assert.Len(t, batch.Urls, 1) {
+		assert.Equal(t, "c1", batch.Urls[0].CorrelationId)
+		assert.Equal(t, "short_URL", batch.Urls[0].ShortUrl)
+	}
+
+	list, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, list.Urls, 1)
+	assert.Equal(t, "url-for-user", list.Urls[0].OriginalUrl)
+
+	_, err = client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{Ids: []string{"short_URL"}})
+	assert.NoError(t, err)
+	_, err = client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{Ids: []string{"broken"}})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	deleteService.AssertNumberOfCalls(t, "DeleteBatch", 2)
+}
+
+func TestServer_Health(t *testing.T) {
+	client, health, _ := newTestClient(t)
+	_, err := client.Ping(context.Background(), &pb.PingRequest{})
+	assert.NoError(t, err)
+
+	res, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: pb.Shortener_ServiceDesc.ServiceName})
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil, map[string]ratelimit.Limit{
+		"shorten":  {Rate: 0.1, Burst: 2},
+		"redirect": {Rate: 0.1, Burst: 1},
+	})
+	client, _, _ := newTestClient(t, func(s *Server) {
+		s.SetRateLimit(limiter, map[string]string{
+			"/shortener.v1.Shortener/Shorten": "shorten",
+			"/shortener.v1.Shortener/Resolve": "redirect",
+		})
+	})
+
+	for i, want := range []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted} {
+		var header metadata.MD
+		_, err := client.Shorten(withToken("user_id"), &pb.ShortenRequest{Url: "original_URL"}, grpc.Header(&header))
+		assert.Equal(t, want, status.Code(err), "call %d", i)
+		if want == codes.ResourceExhausted {
+			assert.Equal(t, []string{"10"}, header.Get("retry-after"))
+		}
+	}
+	// each class has its own buckets, and a new token doesn't help
+	_, err := client.Resolve(context.Background(), &pb.ResolveRequest{Id: "short_URL"})
+	assert.NoError(t, err)
+	_, err = client.Resolve(withToken("other"), &pb.ResolveRequest{Id: "short_URL"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	// methods without a class are not limited
+	_, err = client.Ping(context.Background(), &pb.PingRequest{})
+	assert.NoError(t, err)
+}
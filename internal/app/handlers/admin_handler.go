@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/da-semenov/go-short-url/internal/app/config"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+type Reloader interface {
+	Reload() (config.ReloadResult, error)
+}
+
+// AdminHandler serves the operator endpoints. They are guarded by a bearer
+// token and are disabled while no token is configured.
+type AdminHandler struct {
+	reloader Reloader
+	token    string
+	log      *zap.Logger
+}
+
+func NewAdminHandler(reloader Reloader, token string, log *zap.Logger) *AdminHandler {
+	var h AdminHandler
+	h.reloader = reloader
+	h.token = token
+	h.log = log
+	return &h
+}
+
+func (z *AdminHandler) authorized(r *http.Request) bool {
+	if z.token == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(z.token)) == 1
+}
+
+func (z *AdminHandler) ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !z.authorized(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	log := logger.For(r.Context(), z.log)
+	res, err := z.reloader.Reload()
+	if err != nil {
+		log.Warn("config reload failed", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	log.Info("config reloaded", zap.Strings("applied", res.Applied), zap.Strings("not_reloaded", res.NotReloaded))
+	responseBody, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(responseBody)
+}
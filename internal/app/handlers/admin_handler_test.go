@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"github.com/da-semenov/go-short-url/internal/app/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminHandler_ReloadHandler(t *testing.T) {
+	reloader := new(ReloaderMock)
+	reloader.On("Reload").Return(config.ReloadResult{Applied: []string{"log_level"}, NotReloaded: []string{"server_address"}}, nil)
+
+	tests := []struct {
+		name         string
+		token        string
+		header       string
+		responseCode int
+		response     string
+	}{
+		{name: "Test 1. Disabled without token.", token: "", header: "Bearer ", responseCode: http.StatusForbidden},
+		{name: "Test 2. Wrong token.", token: "secret", header: "Bearer wrong", responseCode: http.StatusForbidden},
+		{name: "Test 3. Reload.", token: "secret", header: "Bearer secret", responseCode: http.StatusOK,
+			response: `{"applied":["log_level"],"not_reloaded":["server_address"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewAdminHandler(reloader, tt.token, zap.NewNop())
+			request := httptest.NewRequest(http.MethodPost, "/api/admin/reload", nil)
+			request.Header.Set("Authorization", tt.header)
+			w := httptest.NewRecorder()
+			http.HandlerFunc(h.ReloadHandler).ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.responseCode, res.StatusCode)
+			if tt.response != "" {
+				body, _ := io.ReadAll(res.Body)
+				assert.JSONEq(t, tt.response, string(body))
+			}
+		})
+	}
+	reloader.AssertNumberOfCalls(t, "Reload", 1)
+}
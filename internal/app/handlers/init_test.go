@@ -3,8 +3,11 @@ package handlers
 import (
 	"errors"
 	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
 	"os"
 	"testing"
+	"time"
 )
 
 var userService *UserServiceMock
@@ -17,6 +20,9 @@ func TestMain(m *testing.M) {
 	userService.On("GetID", "original_URL").Return("short_URL", "short_URL", nil)
 	userService.On("GetID", "bad_URL").Return("short_URL", "short_URL", nil)
 	userService.On("GetID", "").Return("", "", errors.New("url is empty"))
+	userService.On("GetID", "javascript:alert(1)").Return("", "", &urls.ValidationError{Reason: "scheme \"javascript\" is not allowed"})
+
+	userService.On("GetID", "https://evil.example/").Return("", "", &urls.BlockedError{URL: "https://evil.example/", Rule: "evil.example"})
 
 	userService.On("GetURLsByUser", "user_id").Return("url-for-user-1", nil)
 
@@ -24,20 +30,70 @@ func TestMain(m *testing.M) {
 	d = append(d, urls.UserBatch{CorrelationID: "correlation1", OriginalURL: "original_URL_1"})
 	userService.On("SaveBatch", "user_id", d).Return("correlation1", "short_URL_1", nil)
 
+	userService.On("SaveBatch", "user_id", []urls.UserBatch{{CorrelationID: "over", OriginalURL: "quota_URL"}}).
+		Return("", "", &urls.QuotaError{Quota: urls.QuotaLinks, Usage: 10, Limit: 10})
+
 	userService.On("SaveUserURL", "user_id", "original_URL", "short_URL").Return(nil)
 	userService.On("SaveUserURL", "user_id", "bad_URL", "short_URL").Return(urls.ErrDuplicateKey)
 	userService.On("GetURLByShort", "user_id", "short_URL").Return("original_URL", nil)
 	userService.On("GetURLByShort", "", "short_URL").Return("original_URL", nil)
 	userService.On("GetURLByShort", "user_id", "badURL").Return("", urls.ErrNotFound)
 	userService.On("GetURLByShort", "", "badURL").Return("", urls.ErrNotFound)
+	userService.On("GetURLByShort", "", "blocked_URL").Return("", &urls.BlockedError{URL: "https://evil.example/?q=<b>", Rule: "evil.example"})
+
+	userService.On("GetStats").Return(12, 3, nil)
+
+	userService.On("RecordClick", mock.Anything)
+	userService.On("Resolve", "short_URL").Return(&urls.Target{URL: "original_URL"}, nil)
+	userService.On("Resolve", "permanent_URL").Return(&urls.Target{URL: "original_URL", RedirectType: 308}, nil)
+	userService.On("Resolve", "passthrough_URL").Return(&urls.Target{URL: "http://example.com/", QueryPassthrough: true}, nil)
+	userService.On("Resolve", "limited_URL").Return(&urls.Target{URL: "original_URL", Limited: true}, nil)
+	userService.On("Resolve", "used_URL").Return(&urls.Target{URL: "original_URL", Limited: true}, nil)
+	userService.On("TakeClick", "limited_URL").Return(nil)
+	userService.On("TakeClick", "used_URL").Return(urls.ErrNotFound)
+	userService.On("Resolve", "early_URL").Return(nil, &urls.NotActiveError{ActiveFrom: time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)})
+	userService.On("Resolve", "rules_URL").Return(&urls.Target{URL: "https://example.com/", QueryPassthrough: true, Rules: targetingRules}, nil)
+	userService.On("Resolve", "badURL").Return(nil, urls.ErrNotFound)
+	userService.On("Resolve", "short_URL+").Return(nil, urls.ErrNotFound)
+	userService.On("Resolve", "badURL+").Return(nil, urls.ErrNotFound)
+	userService.On("Resolve", "protected_URL").Return(protectedTarget, nil)
+	userService.On("Resolve", "protected_URL+").Return(nil, urls.ErrNotFound)
+	userService.On("Unlock", "protected_URL", "secret").Return(protectedTarget, nil)
+	userService.On("Unlock", "protected_URL", mock.Anything).Return(nil, urls.ErrWrongPassword)
+	userService.On("Unlock", "protected_URL+", mock.Anything).Return(nil, urls.ErrNotFound)
+	userService.On("Unlock", "badURL", mock.Anything).Return(nil, urls.ErrNotFound)
+	userService.On("Resolve", "blocked_URL").Return(nil, &urls.BlockedError{URL: "https://evil.example/?q=<b>", Rule: "evil.example"})
+
+	userService.On("UpdateLink", "user_id", "short_URL", urls.LinkOptions{Title: "New", RedirectType: 301}).Return(nil)
+	userService.On("UpdateLink", "user_id", "short_URL", urls.LinkOptions{RedirectType: 303}).
+		Return(&urls.ValidationError{Field: "redirect_type", Reason: "must be one of [301 302 307 308]"})
+	userService.On("UpdateLink", "user_id", "badURL", urls.LinkOptions{}).Return(urls.ErrNotFound)
+	activeFrom := time.Date(2022, 6, 1, 9, 0, 0, 0, time.UTC)
+	userService.On("UpdateLink", "user_id", "window_URL", urls.LinkOptions{ActiveFrom: &activeFrom}).Return(nil)
+	userService.On("GetLink", "short_URL").Return(&urls.Link{
+		ShortURL:    "http://localhost:8080/short_URL",
+		OriginalURL: "http://example.com/?a=1&b=<2>",
+		Title:       "Owner's title",
+		CreatedAt:   time.Date(2022, 5, 1, 12, 30, 0, 0, time.UTC),
+		Clicks:      42,
+	}, nil)
+	userService.On("GetLink", "protected_URL").Return(&urls.Link{
+		ShortURL:     "http://localhost:8080/protected_URL",
+		OriginalURL:  "http://example.com/secret",
+		PasswordHash: protectedTarget.PasswordHash,
+	}, nil)
+	userService.On("GetLink", "badURL").Return(nil, urls.ErrNotFound)
 
 	cryptoService := new(CryptoServiceMock)
 	cryptoService.On("Validate", "user_id").Return(true, "user_id")
+	cryptoService.On("Validate", "forged").Return(false, "")
 
 	cryptoService.On("GetNewUserToken").Return("user_id", "valid_user_Token", nil)
 
 	deleteService = new(DeleteServiceMock)
+	deleteService.On("DeleteBatch", "user_id", []string{"a", "b", "c"}).
+		Return(&urls.QuotaError{Quota: urls.QuotaDeleteSize, Usage: 3, Limit: 2})
 
-	userHandler = NewUserHandler(userService, cryptoService, deleteService)
+	userHandler = NewUserHandler(userService, cryptoService, deleteService, zap.NewNop(), Config{})
 	os.Exit(m.Run())
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
+	"github.com/da-semenov/go-short-url/internal/app/templates"
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const (
+	// GET /{id}+ or /{id}?preview=1 shows the preview page instead of redirecting
+	previewSuffix = "+"
+	previewParam  = "preview"
+)
+
+func (z *UserHandler) writePreview(w http.ResponseWriter, r *http.Request, key string) {
+	link, err := z.userService.GetLink(r.Context(), key)
+	if errors.Is(err, urls.ErrNotFound) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !z.unlocked(r, key, link.PasswordHash) {
+		z.writePasswordForm(w, r, http.StatusOK, "")
+		return
+	}
+	z.writePage(w, r, http.StatusOK, templates.Preview, link)
+}
+
+// writeInterstitial shows a warning page instead of redirecting to a
+// blocklisted destination.
+func (z *UserHandler) writeInterstitial(w http.ResponseWriter, r *http.Request, url string, rule string) {
+	z.writePage(w, r, http.StatusOK, templates.Interstitial, struct{ URL, Rule string }{url, rule})
+}
+
+// writeNotActive answers for a link whose activation window opens at from.
+func (z *UserHandler) writeNotActive(w http.ResponseWriter, r *http.Request, from time.Time) {
+	w.Header().Set("Retry-After", from.UTC().Format(http.TimeFormat))
+	z.writePage(w, r, z.config.NotActiveStatus, templates.NotActive, struct{ ActiveFrom time.Time }{from})
+}
+
+func (z *UserHandler) writePage(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	var buf bytes.Buffer
+	if err := z.config.Templates.ExecuteTemplate(&buf, name, data); err != nil {
+		logger.For(r.Context(), z.log).Error("can't render page", zap.String("template", name), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
+	"github.com/da-semenov/go-short-url/internal/app/ratelimit"
+	"github.com/da-semenov/go-short-url/internal/app/templates"
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	passwordField = "password"
+	// each protected code gets its own access cookie
+	accessCookiePrefix = "access_"
+)
+
+var errNoAccessSecret = errors.New("link access secret is not set")
+
+func accessCookieName(key string) string {
+	return accessCookiePrefix + base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// accessMAC signs an access cookie for key until expires. The password hash
+// is signed too, so a new password locks out everyone who entered the old
+// one.
+func (z *UserHandler) accessMAC(key string, expires string, passwordHash string) []byte {
+	mac := hmac.New(sha256.New, z.config.AccessSecret)
+	mac.Write([]byte(key + "|" + expires + "|" + passwordHash))
+	return mac.Sum(nil)
+}
+
+// unlocked tells whether the visitor may follow the link: it has no password
+// or the request has a valid access cookie for it.
+func (z *UserHandler) unlocked(r *http.Request, key string, passwordHash string) bool {
+	if passwordHash == "" {
+		return true
+	}
+	if len(z.config.AccessSecret) == 0 {
+		return false
+	}
+	c, err := r.Cookie(accessCookieName(key))
+	if err != nil {
+		return false
+	}
+	// expires|mac
+	parts := strings.Split(c.Value, "|")
+	if len(parts) != 2 {
+		return false
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sum, z.accessMAC(key, parts[0], passwordHash)) {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	return err == nil && time.Now().Unix() < expires
+}
+
+// accessValue is the value of the access cookie for key until expires.
+func (z *UserHandler) accessValue(key string, expires time.Time, passwordHash string) string {
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	return unix + "|" + base64.RawURLEncoding.EncodeToString(z.accessMAC(key, unix, passwordHash))
+}
+
+func (z *UserHandler) grantAccess(w http.ResponseWriter, key string, passwordHash string) error {
+	if len(z.config.AccessSecret) == 0 {
+		return errNoAccessSecret
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookieName(key),
+		Value:    z.accessValue(key, time.Now().Add(z.config.AccessMaxAge), passwordHash),
+		Path:     "/",
+		MaxAge:   int(z.config.AccessMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   z.config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// SetPasswordLimit limits wrong passwords with class of limiter. They are
+// counted per link and client IP, so nobody can lock others out of a link,
+// and a right password costs nothing.
+func (z *UserHandler) SetPasswordLimit(limiter AttemptLimiter, class string) {
+	z.passwordLimiter = limiter
+	z.passwordClass = class
+}
+
+// writePasswordForm asks for the password of a protected link. The form
+// posts back to the same URL, so the query of the visit is kept.
+func (z *UserHandler) writePasswordForm(w http.ResponseWriter, r *http.Request, status int, message string) {
+	z.writePage(w, r, status, templates.Password, struct{ Error string }{message})
+}
+
+// UnlockHandler serves POST /{id}, the password form of a protected link.
+// The right password is remembered in an access cookie and the visitor is
+// sent back to the link.
+func (z *UserHandler) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.RequestURI == "" || r.RequestURI[1:] == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	key := requestKey(r)
+	// the link and its preview share the attempts
+	attemptKey := strings.TrimSuffix(key, previewSuffix) + "|" + z.IPKey(r)
+	if z.passwordLimiter != nil {
+		// the attempt is taken before the password is checked, so concurrent
+		// guesses can't all get in, and given back unless it was wrong
+		if ok, retryAfter := z.passwordLimiter.Take(z.passwordClass, attemptKey); !ok {
+			w.Header().Set("Retry-After", ratelimit.RetryAfter(retryAfter))
+			z.writePasswordForm(w, r, http.StatusTooManyRequests, "Too many wrong passwords, try again later.")
+			return
+		}
+	}
+	password := r.PostForm.Get(passwordField)
+	target, err := z.userService.Unlock(r.Context(), key, password)
+	if errors.Is(err, urls.ErrNotFound) && strings.HasSuffix(key, previewSuffix) {
+		key = strings.TrimSuffix(key, previewSuffix)
+		target, err = z.userService.Unlock(r.Context(), key, password)
+	}
+	if z.passwordLimiter != nil && !errors.Is(err, urls.ErrWrongPassword) {
+		z.passwordLimiter.Give(z.passwordClass, attemptKey)
+	}
+	if errors.Is(err, urls.ErrNotFound) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	if errors.Is(err, urls.ErrWrongPassword) {
+		z.writePasswordForm(w, r, http.StatusForbidden, "Wrong password, try again.")
+		return
+	}
+	var blockedErr *urls.BlockedError
+	if errors.As(err, &blockedErr) {
+		z.writeInterstitial(w, r, blockedErr.URL, blockedErr.Rule)
+		return
+	}
+	var notActiveErr *urls.NotActiveError
+	if errors.As(err, &notActiveErr) {
+		z.writeNotActive(w, r, notActiveErr.ActiveFrom)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err = z.grantAccess(w, key, target.PasswordHash); err != nil {
+		logger.For(r.Context(), z.log).Error("can't issue access cookie", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"github.com/da-semenov/go-short-url/internal/app/ratelimit"
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var protectedTarget = &urls.Target{URL: "http://example.com/secret", PasswordHash: "$2a$10$saltsaltsaltsaltsaltsuhashhashhashhashhashhashhash1"}
+
+var accessSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestUserHandler_UnlockHandler(t *testing.T) {
+	cryptoService := new(CryptoServiceMock)
+	cryptoService.On("GetNewUserToken").Return("user_id", "valid_user_Token", nil)
+	cryptoService.On("Validate", mock.Anything).Return(true, "")
+	h := NewUserHandler(userService, cryptoService, deleteService, zap.NewNop(), Config{AccessSecret: accessSecret})
+	r := chi.NewRouter()
+	r.Get("/{id}", h.GetMethodHandler)
+	r.Post("/{id}", h.UnlockHandler)
+
+	tests := []struct {
+		name         string
+		target       string
+		password     string
+		responseCode int
+		location     string
+		wantBody     string
+	}{
+		{name: "Test 1. Right password.", target: "/protected_URL?ref=news", password: "secret",
+			responseCode: http.StatusSeeOther, location: "/protected_URL?ref=news"},
+		{name: "Test 2. Right password on the preview.", target: "/protected_URL+", password: "secret",
+			responseCode: http.StatusSeeOther, location: "/protected_URL+"},
+		{name: "Test 3. Wrong password.", target: "/protected_URL", password: "Secret",
+			responseCode: http.StatusForbidden, wantBody: "Wrong password"},
+		{name: "Test 4. Unknown code.", target: "/badURL", password: "secret", responseCode: http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(url.Values{"password": {tt.password}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			res := w.Result()
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tt.responseCode, res.StatusCode)
+			assert.Equal(t, tt.location, res.Header.Get("Location"))
+			assert.Contains(t, string(body), tt.wantBody)
+			if tt.responseCode != http.StatusSeeOther {
+				assert.Empty(t, res.Cookies())
+				return
+			}
+			cookies := res.Cookies()
+			assert.Len(t, cookies, 1)
+			assert.Equal(t, accessCookieName("protected_URL"), cookies[0].Name)
+			assert.True(t, cookies[0].HttpOnly)
+
+			// the cookie lets the visitor through
+			req = httptest.NewRequest(http.MethodGet, tt.location, nil)
+			req.AddCookie(cookies[0])
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			res = w.Result()
+			defer res.Body.Close()
+			if strings.HasSuffix(tt.location, previewSuffix) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				body, _ = io.ReadAll(res.Body)
+				assert.Contains(t, string(body), "http://example.com/secret")
+				return
+			}
+			assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+			assert.Equal(t, "http://example.com/secret", res.Header.Get("Location"))
+			assert.Equal(t, "private, no-store", res.Header.Get("Cache-Control"))
+		})
+	}
+}
+
+func TestUserHandler_PasswordForm(t *testing.T) {
+	cryptoService := new(CryptoServiceMock)
+	cryptoService.On("GetNewUserToken").Return("user_id", "valid_user_Token", nil)
+	cryptoService.On("Validate", mock.Anything).Return(true, "")
+	h := NewUserHandler(userService, cryptoService, deleteService, zap.NewNop(), Config{AccessSecret: accessSecret})
+	other := NewUserHandler(userService, cryptoService, deleteService, zap.NewNop(), Config{AccessSecret: []byte("another secret of thirty-two byte")})
+	expires, expired := time.Now().Add(time.Hour), time.Now().Add(-time.Hour)
+	hash := protectedTarget.PasswordHash
+	cookie := func(value string) *http.Cookie {
+		return &http.Cookie{Name: accessCookieName("protected_URL"), Value: value}
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		cookie *http.Cookie
+	}{
+		{name: "Test 1. No cookie.", target: "/protected_URL"},
+		{name: "Test 2. Preview without a cookie.", target: "/protected_URL?preview=1"},
+		{name: "Test 3. Cookie of another code.", target: "/protected_URL", cookie: cookie(h.accessValue("short_URL", expires, hash))},
+		{name: "Test 4. Expired cookie.", target: "/protected_URL", cookie: cookie(h.accessValue("protected_URL", expired, hash))},
+		{name: "Test 5. Cookie of an old password.", target: "/protected_URL", cookie: cookie(h.accessValue("protected_URL", expires, "oldhash"))},
+		{name: "Test 6. Cookie signed with another secret.", target: "/protected_URL", cookie: cookie(other.accessValue("protected_URL", expires, hash))},
+		{name: "Test 7. Forged cookie.", target: "/protected_URL", cookie: cookie("99999999999|" + strings.Repeat("A", 43))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			w := httptest.NewRecorder()
+			h.GetMethodHandler(w, req)
+			res := w.Result()
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Empty(t, res.Header.Get("Location"))
+			assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+			assert.Contains(t, string(body), `type="password"`)
+			assert.NotContains(t, string(body), "example.com/secret")
+		})
+	}
+}
+
+func TestUserHandler_UnlockLimit(t *testing.T) {
+	h := NewUserHandler(userService, new(CryptoServiceMock), deleteService, zap.NewNop(), Config{AccessSecret: accessSecret})
+	h.SetPasswordLimit(ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil, map[string]ratelimit.Limit{
+		"password": {Rate: 0.01, Burst: 2},
+	}), "password")
+	r := chi.NewRouter()
+	r.Post("/{id}", h.UnlockHandler)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		password     string
+		responseCode int
+	}{
+		{name: "Test 1. Right password.", remoteAddr: "192.0.2.1:1234", password: "secret", responseCode: http.StatusSeeOther},
+		{name: "Test 2. Right password is not counted.", remoteAddr: "192.0.2.1:1234", password: "secret", responseCode: http.StatusSeeOther},
+		{name: "Test 3. Wrong password.", remoteAddr: "192.0.2.1:1234", password: "guess1", responseCode: http.StatusForbidden},
+		{name: "Test 4. Wrong password.", remoteAddr: "192.0.2.1:1234", password: "guess2", responseCode: http.StatusForbidden},
+		{name: "Test 5. Out of attempts.", remoteAddr: "192.0.2.1:1234", password: "guess3", responseCode: http.StatusTooManyRequests},
+		{name: "Test 6. Out of attempts, even with the right password.", remoteAddr: "192.0.2.1:1234", password: "secret", responseCode: http.StatusTooManyRequests},
+		{name: "Test 7. Other clients are not locked out.", remoteAddr: "192.0.2.2:1234", password: "secret", responseCode: http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/protected_URL", strings.NewReader(url.Values{"password": {tt.password}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.responseCode, res.StatusCode)
+			if tt.responseCode == http.StatusTooManyRequests {
+				assert.Equal(t, "100", res.Header.Get("Retry-After"))
+			}
+		})
+	}
+
+	// guesses sent at once get no more attempts than guesses sent in turn
+	codes := make(chan int, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/protected_URL", strings.NewReader(url.Values{"password": {"guess"}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.RemoteAddr = "192.0.2.3:1234"
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	count := make(map[int]int)
+	for code := range codes {
+		count[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusForbidden: 2, http.StatusTooManyRequests: 8}, count)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
+	"github.com/da-semenov/go-short-url/internal/app/qr"
+	"github.com/da-semenov/go-short-url/internal/app/ratelimit"
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+// GET /{id}.png is a shortcut for the PNG code of /{id}; short codes never
+// contain a dot.
+const qrSuffix = ".png"
+
+// maxQRSize bounds the size a client may ask for; a 4096 pixel PNG takes
+// 16MB to draw. Larger sizes are lowered to it, or to the configured size
+// if that is larger.
+const maxQRSize = 1024
+
+// SetQRLimit limits QR codes with class of limiter, for both the API route
+// and the /{id}.png shortcut.
+func (z *UserHandler) SetQRLimit(limiter AttemptLimiter, class string) {
+	z.qrLimiter = limiter
+	z.qrClass = class
+}
+
+// QRHandler serves GET /api/user/urls/{id}/qr. The format (png or svg), size,
+// margin and level query parameters override the configured defaults.
+func (z *UserHandler) QRHandler(w http.ResponseWriter, r *http.Request) {
+	z.writeQR(w, r, chi.URLParam(r, "id"))
+}
+
+func (z *UserHandler) writeQR(w http.ResponseWriter, r *http.Request, key string) {
+	if z.qrLimiter != nil {
+		if ok, retryAfter := z.qrLimiter.Take(z.qrClass, z.ClientKeys(r)...); !ok {
+			w.Header().Set("Retry-After", ratelimit.RetryAfter(retryAfter))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+	}
+	q := r.URL.Query()
+	opts := z.config.QR
+	if v := q.Get("size"); v != "" {
+		opts.Size, _ = strconv.Atoi(v)
+		maxSize := maxQRSize
+		if z.config.QR.Size > maxSize {
+			maxSize = z.config.QR.Size
+		}
+		if opts.Size > maxSize {
+			opts.Size = maxSize
+		}
+	}
+	if v := q.Get("margin"); v != "" {
+		opts.Margin, _ = strconv.Atoi(v)
+	}
+	if v := q.Get("level"); v != "" {
+		opts.Level = v
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		http.Error(w, "format must be png or svg", http.StatusBadRequest)
+		return
+	}
+
+	_, err := z.userService.GetURLByShort(r.Context(), "", key)
+	if errors.Is(err, urls.ErrNotFound) {
+		http.Error(w, "url was not found", http.StatusNotFound)
+		return
+	}
+	if writeValidationError(w, err) {
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var body []byte
+	if format == "svg" {
+		body, err = qr.SVG(z.config.BaseURL+key, opts)
+		w.Header().Set("Content-Type", "image/svg+xml")
+	} else {
+		body, err = qr.PNG(z.config.BaseURL+key, opts)
+		w.Header().Set("Content-Type", "image/png")
+	}
+	if err != nil {
+		logger.For(r.Context(), z.log).Error("can't encode qr code", zap.Error(err))
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/da-semenov/go-short-url/internal/app/qr"
+	"github.com/da-semenov/go-short-url/internal/app/ratelimit"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUserHandler_QRHandler(t *testing.T) {
+	cryptoService := new(CryptoServiceMock)
+	cryptoService.On("GetNewUserToken").Return("user_id", "valid_user_Token", nil)
+	h := NewUserHandler(userService, cryptoService, deleteService, zap.NewNop(), Config{
+		BaseURL: "http://localhost:8080/",
+		QR:      qr.Options{Size: 128, Margin: 4, Level: "M"},
+	})
+	r := chi.NewRouter()
+	r.Get("/{id}", h.GetMethodHandler)
+	r.Get("/api/user/urls/{id}/qr", h.QRHandler)
+
+	tests := []struct {
+		name         string
+		target       string
+		responseCode int
+		contentType  string
+		wantSize     int
+	}{
+		{name: "Test 1. PNG.", target: "/api/user/urls/short_URL/qr", responseCode: http.StatusOK, contentType: "image/png", wantSize: 128},
+		{name: "Test 2. PNG shortcut.", target: "/short_URL.png?size=200", responseCode: http.StatusOK, contentType: "image/png", wantSize: 200},
+		{name: "Test 3. SVG.", target: "/api/user/urls/short_URL/qr?format=svg&level=H&margin=0", responseCode: http.StatusOK, contentType: "image/svg+xml"},
+		{name: "Test 4. Unknown code.", target: "/api/user/urls/badURL/qr", responseCode: http.StatusNotFound},
+		{name: "Test 5. Blocklisted destination.", target: "/blocked_URL.png", responseCode: http.StatusForbidden},
+		{name: "Test 6. Bad size.", target: "/api/user/urls/short_URL/qr?size=0", responseCode: http.StatusBadRequest},
+		{name: "Test 7. Bad format.", target: "/api/user/urls/short_URL/qr?format=gif", responseCode: http.StatusBadRequest},
+		{name: "Test 8. Size over the bound.", target: "/api/user/urls/short_URL/qr?size=100000", responseCode: http.StatusOK, contentType: "image/png", wantSize: maxQRSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			res := w.Result()
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tt.responseCode, res.StatusCode)
+			if tt.contentType == "" {
+				return
+			}
+			assert.Equal(t, tt.contentType, res.Header.Get("Content-Type"))
+			if tt.contentType == "image/png" {
+				img, err := png.Decode(bytes.NewReader(body))
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantSize, img.Bounds().Dx())
+			} else {
+				assert.True(t, strings.HasPrefix(string(body), "<svg"))
+			}
+		})
+	}
+}
+
+func TestUserHandler_QRLimit(t *testing.T) {
+	cryptoService := new(CryptoServiceMock)
+	cryptoService.On("GetNewUserToken").Return("user_id", "valid_user_Token", nil)
+	h := NewUserHandler(userService, cryptoService, deleteService, zap.NewNop(), Config{
+		BaseURL: "http://localhost:8080/",
+		QR:      qr.Options{Size: 128, Margin: 4, Level: "M"},
+	})
+	h.SetQRLimit(ratelimit.NewLimiter(ratelimit.NewMemoryStore(), nil, map[string]ratelimit.Limit{
+		"qr": {Rate: 0.001, Burst: 2},
+	}), "qr")
+	r := chi.NewRouter()
+	r.Get("/{id}", h.GetMethodHandler)
+	r.Get("/api/user/urls/{id}/qr", h.QRHandler)
+
+	// the shortcut and the API route share the limit
+	for i, target := range []string{"/short_URL.png", "/api/user/urls/short_URL/qr", "/short_URL.png"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if i < 2 {
+			assert.Equal(t, http.StatusOK, w.Code, target)
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, w.Code, target)
+			assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// permanentMaxAge is how long a browser may cache a 301 or 308.
+const permanentMaxAge = 5 * time.Minute
+
+func (z *UserHandler) redirect(w http.ResponseWriter, r *http.Request, target *urls.Target) {
+	status := target.RedirectType
+	if status == 0 {
+		status = z.config.RedirectType
+	}
+	w.Header().Set("Cache-Control", redirectCacheControl(target, status, time.Now()))
+	if len(target.Rules) > 0 {
+		// the destination depends on who follows the link
+		w.Header().Set("Vary", "User-Agent, Accept-Language")
+		routed := *target
+		routed.URL = route(target.Rules, r, target.URL)
+		target = &routed
+	}
+	w.Header().Set("Location", destination(target, r.URL.Query()))
+	w.WriteHeader(status)
+}
+
+// redirectCacheControl lets the browser cache a permanent redirect for a
+// few minutes, while temporary ones are checked with the server every time.
+// Any link can still be edited, deleted or blocklisted, and a cached redirect
+// outlives that change, so permanent ones are private, for shared caches
+// can't be purged, and short: a repeat visit is saved a round trip at the
+// cost of following an edit up to permanentMaxAge late and not being
+// counted as a click. The redirect of a password link or of one with
+// max_clicks is never stored: a cache would hand it out without the password
+// or past the last click. Nor is a redirect cached past the active_until of
+// its link.
+func redirectCacheControl(target *urls.Target, status int, now time.Time) string {
+	if target.PasswordHash != "" || target.Limited {
+		return "private, no-store"
+	}
+	switch status {
+	case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		maxAge := permanentMaxAge
+		if target.ActiveUntil != nil && target.ActiveUntil.Sub(now) < maxAge {
+			maxAge = target.ActiveUntil.Sub(now)
+		}
+		if maxAge < time.Second {
+			return "private, no-store"
+		}
+		return "private, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	default:
+		return "private, no-cache"
+	}
+}
+
+// destination adds the query of the visit, if the link passes it through,
+// and the UTM parameters of the link to the target URL. A parameter the
+// destination already has keeps its value, except a UTM one when the link
+// says override; with keep, a UTM parameter passed through from the visit
+// wins too. The URL is returned as stored when nothing is added.
+func destination(target *urls.Target, visit url.Values) string {
+	if (!target.QueryPassthrough || len(visit) == 0) && target.UTM == nil {
+		return target.URL
+	}
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return target.URL
+	}
+	q := u.Query()
+	changed := false
+	if target.QueryPassthrough {
+		for k, v := range visit {
+			if _, exists := q[k]; !exists {
+				q[k] = v
+				changed = true
+			}
+		}
+	}
+	if target.UTM != nil {
+		override := target.UTM.Conflict == urls.UTMOverride
+		for _, p := range []struct{ key, value string }{
+			{"utm_source", target.UTM.Source},
+			{"utm_medium", target.UTM.Medium},
+			{"utm_campaign", target.UTM.Campaign},
+		} {
+			if _, exists := q[p.key]; p.value == "" || (exists && !override) {
+				continue
+			}
+			q.Set(p.key, p.value)
+			changed = true
+		}
+	}
+	if !changed {
+		return target.URL
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_destination(t *testing.T) {
+	utm := &urls.UTM{Source: "news", Medium: "email", Campaign: "spring"}
+	tests := []struct {
+		name   string
+		target urls.Target
+		visit  string
+		want   string
+	}{
+		{name: "Test 1. Nothing to add.", target: urls.Target{URL: "http://example.com/a?b=2&a=1"}, visit: "ref=x",
+			want: "http://example.com/a?b=2&a=1"},
+		{name: "Test 2. Passthrough.", target: urls.Target{URL: "http://example.com/a?id=1", QueryPassthrough: true}, visit: "ref=newsletter&id=2",
+			want: "http://example.com/a?id=1&ref=newsletter"},
+		{name: "Test 3. UTM.", target: urls.Target{URL: "http://example.com/", UTM: utm},
+			want: "http://example.com/?utm_campaign=spring&utm_medium=email&utm_source=news"},
+		{name: "Test 4. UTM keeps the destination value.", target: urls.Target{URL: "http://example.com/?utm_source=site", UTM: &urls.UTM{Source: "news", Medium: "email"}},
+			want: "http://example.com/?utm_medium=email&utm_source=site"},
+		{name: "Test 5. UTM overrides.", target: urls.Target{URL: "http://example.com/?utm_source=site", UTM: &urls.UTM{Source: "news", Conflict: urls.UTMOverride}},
+			want: "http://example.com/?utm_source=news"},
+		{name: "Test 6. UTM from the visit wins with keep.", target: urls.Target{URL: "http://example.com/", QueryPassthrough: true, UTM: &urls.UTM{Source: "news"}}, visit: "utm_source=friend",
+			want: "http://example.com/?utm_source=friend"},
+		{name: "Test 7. Fragment is kept.", target: urls.Target{URL: "http://example.com/p#top", UTM: &urls.UTM{Campaign: "spring"}},
+			want: "http://example.com/p?utm_campaign=spring#top"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visit, err := url.ParseQuery(tt.visit)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, destination(&tt.target, visit))
+		})
+	}
+}
+
+func Test_redirectCacheControl(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	soon, later := now.Add(90*time.Second), now.Add(72*time.Hour)
+	tests := []struct {
+		name   string
+		target urls.Target
+		status int
+		want   string
+	}{
+		{name: "Test 1. Temporary.", status: http.StatusTemporaryRedirect, want: "private, no-cache"},
+		{name: "Test 2. Permanent.", status: http.StatusPermanentRedirect, want: "private, max-age=300"},
+		{name: "Test 3. Permanent with a password.", target: urls.Target{PasswordHash: "hash"}, status: http.StatusMovedPermanently,
+			want: "private, no-store"},
+		{name: "Test 4. Permanent with max clicks.", target: urls.Target{Limited: true}, status: http.StatusPermanentRedirect,
+			want: "private, no-store"},
+		{name: "Test 5. Permanent until soon.", target: urls.Target{ActiveUntil: &soon}, status: http.StatusMovedPermanently,
+			want: "private, max-age=90"},
+		{name: "Test 6. Permanent until later.", target: urls.Target{ActiveUntil: &later}, status: http.StatusMovedPermanently,
+			want: "private, max-age=300"},
+		{name: "Test 7. Permanent until now.", target: urls.Target{ActiveUntil: &now}, status: http.StatusMovedPermanently,
+			want: "private, no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, redirectCacheControl(&tt.target, tt.status, now))
+		})
+	}
+}
+
+func TestUserHandler_GetMethodHandlerPassthrough(t *testing.T) {
+	w := httptest.NewRecorder()
+	userHandler.GetMethodHandler(w, httptest.NewRequest(http.MethodGet, "/passthrough_URL?ref=newsletter", nil))
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	assert.Equal(t, "http://example.com/?ref=newsletter", res.Header.Get("Location"))
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"github.com/da-semenov/go-short-url/internal/app/config"
 	"github.com/da-semenov/go-short-url/internal/app/urls"
 	"github.com/stretchr/testify/mock"
 )
@@ -22,7 +23,7 @@ func (s *UserServiceMock) Ping(ctx context.Context) bool {
 	return args.Bool(0)
 }
 
-func (s *UserServiceMock) SaveUserURL(ctx context.Context, userID string, originalURL string, shortURL string) error {
+func (s *UserServiceMock) SaveUserURL(ctx context.Context, userID string, originalURL string, shortURL string, opts urls.LinkOptions) error {
 	args := s.Called(userID, originalURL, shortURL)
 	if originalURL == "bad_URL" {
 		return args.Error(0)
@@ -43,11 +44,48 @@ func (s *UserServiceMock) GetURLByShort(ctx context.Context, userID string, shor
 	return args.String(0), args.Error(1)
 }
 
-func (s *UserServiceMock) GetID(url string) (string, string, error) {
+func (s *UserServiceMock) GetID(url string, opts urls.LinkOptions) (string, string, error) {
 	args := s.Called(url)
 	return args.String(0), args.String(1), args.Error(2)
 }
 
+func (s *UserServiceMock) GetLink(ctx context.Context, shortURL string) (*urls.Link, error) {
+	args := s.Called(shortURL)
+	res, _ := args.Get(0).(*urls.Link)
+	return res, args.Error(1)
+}
+
+func (s *UserServiceMock) RecordClick(ctx context.Context, shortURL string) {
+	s.Called(shortURL)
+}
+
+func (s *UserServiceMock) Resolve(ctx context.Context, shortURL string) (*urls.Target, error) {
+	args := s.Called(shortURL)
+	res, _ := args.Get(0).(*urls.Target)
+	return res, args.Error(1)
+}
+
+func (s *UserServiceMock) UpdateLink(ctx context.Context, userID string, shortURL string, opts urls.LinkOptions) error {
+	args := s.Called(userID, shortURL, opts)
+	return args.Error(0)
+}
+
+func (s *UserServiceMock) Unlock(ctx context.Context, shortURL string, password string) (*urls.Target, error) {
+	args := s.Called(shortURL, password)
+	res, _ := args.Get(0).(*urls.Target)
+	return res, args.Error(1)
+}
+
+func (s *UserServiceMock) TakeClick(ctx context.Context, shortURL string) error {
+	args := s.Called(shortURL)
+	return args.Error(0)
+}
+
+func (s *UserServiceMock) GetStats(ctx context.Context) (*urls.Stats, error) {
+	args := s.Called()
+	return &urls.Stats{URLs: args.Int(0), Users: args.Int(1)}, args.Error(2)
+}
+
 type CryptoServiceMock struct {
 	mock.Mock
 }
@@ -70,3 +108,12 @@ func (s *DeleteServiceMock) DeleteBatch(ctx context.Context, userID string, URLL
 	args := s.Called(userID, URLList)
 	return args.Error(0)
 }
+
+type ReloaderMock struct {
+	mock.Mock
+}
+
+func (s *ReloaderMock) Reload() (config.ReloadResult, error) {
+	args := s.Called()
+	return args.Get(0).(config.ReloadResult), args.Error(1)
+}
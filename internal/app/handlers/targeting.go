@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// route picks the destination of the first rule that matches the visitor,
+// or fallback when none does.
+func route(rules []urls.Rule, r *http.Request, fallback string) string {
+	if len(rules) == 0 {
+		return fallback
+	}
+	p := platform(r.UserAgent())
+	lang := preferredLanguage(r.Header.Get("Accept-Language"))
+	for _, rule := range rules {
+		if rule.Platform != "" && rule.Platform != p {
+			continue
+		}
+		if rule.Language != "" && !matchLanguage(rule.Language, lang) {
+			continue
+		}
+		return rule.URL
+	}
+	return fallback
+}
+
+// platform tells the platform of a user agent, one of urls.Platforms, or ""
+// when it's none of them. iOS and Android are checked first, as their user
+// agents mention macOS and Linux too.
+func platform(userAgent string) string {
+	switch {
+	case isIOS(userAgent):
+		return "ios"
+	case strings.Contains(userAgent, "Android"):
+		return "android"
+	case strings.Contains(userAgent, "Windows"):
+		return "windows"
+	case strings.Contains(userAgent, "Macintosh"), strings.Contains(userAgent, "Mac OS X"):
+		return "macos"
+	case strings.Contains(userAgent, "Linux"):
+		return "linux"
+	default:
+		return ""
+	}
+}
+
+// iosTokens are only found in user agents of iOS and iPadOS. Browsers other
+// than Safari keep their token when they ask for the desktop site.
+var iosTokens = []string{"iPhone", "iPad", "iPod", "CriOS/", "FxiOS/", "EdgiOS/"}
+
+// isIOS tells whether a user agent is of an iPhone or an iPad. Since iPadOS
+// 13 an iPad asks for the desktop site by default and claims to be a Mac;
+// in-app browsers still give it away with the Mobile token, but Safari sends
+// just what Safari on macOS does, so such an iPad is routed as macos.
+func isIOS(userAgent string) bool {
+	for _, token := range iosTokens {
+		if strings.Contains(userAgent, token) {
+			return true
+		}
+	}
+	return strings.Contains(userAgent, "Macintosh") && strings.Contains(userAgent, "Mobile/")
+}
+
+// preferredLanguage returns the lowercased language with the highest
+// quality in an Accept-Language header; the first one wins a tie.
+func preferredLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if len(fields) > 1 {
+			param := strings.TrimSpace(fields[1])
+			if strings.HasPrefix(param, "q=") {
+				parsed, err := strconv.ParseFloat(param[len("q="):], 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+// matchLanguage tells whether the rule language matches lang, either the
+// same tag or a regional variant of it.
+func matchLanguage(rule string, lang string) bool {
+	return lang == rule || strings.HasPrefix(lang, rule+"-")
+}
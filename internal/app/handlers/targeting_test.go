@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 15_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.4 Mobile/15E148 Safari/604.1"
+	androidUA = "Mozilla/5.0 (Linux; Android 12; Pixel 6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/101.0.4951.41 Mobile Safari/537.36"
+	macUA     = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.4 Safari/605.1.15"
+)
+
+var targetingRules = []urls.Rule{
+	{Platform: "ios", URL: "https://apps.apple.com/app/id1"},
+	{Platform: "android", URL: "https://play.google.com/store/apps/details?id=app"},
+	{Language: "de", URL: "https://example.com/de/"},
+	{Platform: "macos", Language: "pt-br", URL: "https://example.com/br/mac/"},
+}
+
+func Test_route(t *testing.T) {
+	tests := []struct {
+		name           string
+		userAgent      string
+		acceptLanguage string
+		want           string
+	}{
+		{name: "Test 1. iOS.", userAgent: iPhoneUA, acceptLanguage: "de-DE", want: "https://apps.apple.com/app/id1"},
+		{name: "Test 2. Android.", userAgent: androidUA, want: "https://play.google.com/store/apps/details?id=app"},
+		{name: "Test 3. Language with a region.", userAgent: macUA, acceptLanguage: "de-AT,de;q=0.9,en;q=0.8", want: "https://example.com/de/"},
+		{name: "Test 4. Preferred language only.", userAgent: macUA, acceptLanguage: "fr;q=0.9,de;q=0.5", want: "https://example.com/"},
+		{name: "Test 5. Quality decides.", userAgent: macUA, acceptLanguage: "en;q=0.3, DE;q=0.8", want: "https://example.com/de/"},
+		{name: "Test 6. Platform and language.", userAgent: macUA, acceptLanguage: "pt-BR", want: "https://example.com/br/mac/"},
+		{name: "Test 7. Region doesn't match.", userAgent: macUA, acceptLanguage: "pt-PT", want: "https://example.com/"},
+		{name: "Test 8. Fallback.", userAgent: "curl/7.79.1", acceptLanguage: "*", want: "https://example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/rules_URL", nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+			assert.Equal(t, tt.want, route(targetingRules, r, "https://example.com/"))
+		})
+	}
+}
+
+func Test_platform(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{name: "Test 1. iPhone.", userAgent: iPhoneUA, want: "ios"},
+		{name: "Test 2. iPad before iPadOS 13.", userAgent: "Mozilla/5.0 (iPad; CPU OS 12_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1 Mobile/15E148 Safari/604.1",
+			want: "ios"},
+		{name: "Test 3. In-app browser on an iPad.", userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+			want: "ios"},
+		{name: "Test 4. Chrome on an iPad.", userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/101.0.4951.58 Mobile/15E148 Safari/604.1",
+			want: "ios"},
+		// Safari on iPadOS 13+ sends the user agent of Safari on macOS
+		{name: "Test 5. Safari on an iPad can't be told from a Mac.", userAgent: macUA, want: "macos"},
+		{name: "Test 6. Chrome on a Mac.", userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/101.0.4951.64 Safari/537.36",
+			want: "macos"},
+		{name: "Test 7. Android.", userAgent: androidUA, want: "android"},
+		{name: "Test 8. Unknown.", userAgent: "curl/7.79.1", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, platform(tt.userAgent))
+		})
+	}
+}
+
+func TestUserHandler_GetMethodHandlerRules(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/rules_URL?ref=ad", nil)
+	r.Header.Set("User-Agent", androidUA)
+	w := httptest.NewRecorder()
+	userHandler.GetMethodHandler(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	assert.Equal(t, "https://play.google.com/store/apps/details?id=app&ref=ad", res.Header.Get("Location"))
+	assert.Equal(t, "User-Agent, Accept-Language", res.Header.Get("Vary"))
+}
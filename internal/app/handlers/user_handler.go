@@ -4,11 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
+	"github.com/da-semenov/go-short-url/internal/app/qr"
+	"github.com/da-semenov/go-short-url/internal/app/templates"
+	"github.com/da-semenov/go-short-url/internal/app/tracing"
 	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"html/template"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
+var tracer = tracing.Tracer("github.com/da-semenov/go-short-url/internal/app/handlers")
+
 type CryptoService interface {
 	Validate(token string) (bool, string)
 	GetNewUserToken() (string, string, error)
@@ -16,28 +27,99 @@ type CryptoService interface {
 
 type UserService interface {
 	GetURLsByUser(ctx context.Context, userID string) ([]urls.UserURLs, error)
-	SaveUserURL(ctx context.Context, userID string, originalURL string, shortURL string) error
+	SaveUserURL(ctx context.Context, userID string, originalURL string, shortURL string, opts urls.LinkOptions) error
 	SaveBatch(ctx context.Context, userID string, src []urls.UserBatch) ([]urls.UserBatchResult, error)
 	GetURLByShort(ctx context.Context, userID string, shortURL string) (string, error)
-	GetID(url string) (string, string, error)
+	GetID(url string, opts urls.LinkOptions) (string, string, error)
 	Ping(ctx context.Context) bool
+	GetStats(ctx context.Context) (*urls.Stats, error)
+	GetLink(ctx context.Context, shortURL string) (*urls.Link, error)
+	RecordClick(ctx context.Context, shortURL string)
+	Resolve(ctx context.Context, shortURL string) (*urls.Target, error)
+	UpdateLink(ctx context.Context, userID string, shortURL string, opts urls.LinkOptions) error
+	Unlock(ctx context.Context, shortURL string, password string) (*urls.Target, error)
+	TakeClick(ctx context.Context, shortURL string) error
 }
 
 type DeleteService interface {
 	DeleteBatch(ctx context.Context, userID string, URLList []string) error
 }
 
+// AttemptLimiter counts wrong passwords and QR codes per client, as
+// ratelimit.Limiter does.
+type AttemptLimiter interface {
+	Take(class string, keys ...string) (bool, time.Duration)
+	Give(class string, keys ...string)
+}
+
+// Config holds the handler settings that come from AppConfig.
+type Config struct {
+	CookieName     string
+	CookieMaxAge   time.Duration
+	CookieHTTPOnly bool
+	CookieSecure   bool
+	// Templates render the HTML pages; nil uses the embedded ones.
+	Templates *template.Template
+	// BaseURL and QR are used to draw QR codes of short links.
+	BaseURL string
+	QR      qr.Options
+	// RedirectType is the redirect status of links that don't set their own.
+	RedirectType int
+	// AccessMaxAge is how long a visitor who entered the password of a
+	// protected link may use it without entering it again.
+	AccessMaxAge time.Duration
+	// AccessSecret signs the cookies that remember the password; without it
+	// protected links can't be unlocked.
+	AccessSecret []byte
+	// NotActiveStatus is the status of the page shown for a link whose
+	// activation window has not opened yet.
+	NotActiveStatus int
+	// TrustedProxies are the load balancers and proxies whose
+	// X-Forwarded-For and X-Real-IP headers tell the client IP.
+	TrustedProxies []*net.IPNet
+}
+
+const (
+	defaultCookieName   = "token"
+	defaultAccessMaxAge = 24 * time.Hour
+)
+
 type UserHandler struct {
 	userService   UserService
 	cryptoService CryptoService
 	DeleteService DeleteService
+	log           *zap.Logger
+	config        Config
+	// passwordLimiter is nil when wrong passwords are not limited
+	passwordLimiter AttemptLimiter
+	passwordClass   string
+	// qrLimiter is nil when QR codes are not limited
+	qrLimiter AttemptLimiter
+	qrClass   string
 }
 
-func NewUserHandler(us UserService, cs CryptoService, ds DeleteService) *UserHandler {
+func NewUserHandler(us UserService, cs CryptoService, ds DeleteService, log *zap.Logger, cfg Config) *UserHandler {
 	var h UserHandler
 	h.userService = us
 	h.cryptoService = cs
 	h.DeleteService = ds
+	h.log = log
+	h.config = cfg
+	if h.config.CookieName == "" {
+		h.config.CookieName = defaultCookieName
+	}
+	if h.config.RedirectType == 0 {
+		h.config.RedirectType = http.StatusTemporaryRedirect
+	}
+	if h.config.NotActiveStatus == 0 {
+		h.config.NotActiveStatus = http.StatusForbidden
+	}
+	if h.config.AccessMaxAge == 0 {
+		h.config.AccessMaxAge = defaultAccessMaxAge
+	}
+	if h.config.Templates == nil {
+		h.config.Templates = templates.Default()
+	}
 	return &h
 }
 
@@ -47,29 +129,35 @@ func (z *UserHandler) makeCookie() (*http.Cookie, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
-	c.Name = "token"
+	c.Name = z.config.CookieName
 	c.Value = token
+	c.MaxAge = int(z.config.CookieMaxAge.Seconds())
+	c.HttpOnly = z.config.CookieHTTPOnly
+	c.Secure = z.config.CookieSecure
 	return &c, userID, nil
 }
 
 func (z *UserHandler) getTokenCookie(w http.ResponseWriter, r *http.Request) (string, error) {
 	var userID string
 	var ok bool
-	token, err := r.Cookie("token")
+	_, span := tracer.Start(r.Context(), "UserHandler.getTokenCookie")
+	defer span.End()
+	log := logger.For(r.Context(), z.log)
+	token, err := r.Cookie(z.config.CookieName)
 	if err == nil {
 		ok, userID = z.cryptoService.Validate(token.Value)
 		if !ok {
-			fmt.Println("invalid cookie")
+			log.Info("invalid token cookie", zap.String("token", logger.Redact(token.Value)))
 		}
 	}
 	if errors.Is(err, http.ErrNoCookie) || !ok {
-		fmt.Println("new cookie")
 		var newToken *http.Cookie
 		newToken, userID, err = z.makeCookie()
-		fmt.Println(userID, " ", newToken)
 		if err != nil {
+			log.Error("can't issue token", zap.Error(err))
 			return "", err
 		}
+		log.Debug("issued new token cookie")
 		http.SetCookie(w, newToken)
 		token = newToken
 	} else if err != nil {
@@ -79,6 +167,68 @@ func (z *UserHandler) getTokenCookie(w http.ResponseWriter, r *http.Request) (st
 	return userID, nil
 }
 
+// ClientKeys identifies the client for rate limiting: the client IP and, with
+// a valid token cookie, the user too. Both are counted, as a new token cookie
+// is free to get and must not bring a fresh limit.
+func (z *UserHandler) ClientKeys(r *http.Request) []string {
+	keys := []string{z.IPKey(r)}
+	if token, err := r.Cookie(z.config.CookieName); err == nil {
+		if ok, userID := z.cryptoService.Validate(token.Value); ok {
+			keys = append(keys, "user:"+userID)
+		}
+	}
+	return keys
+}
+
+// IPKey identifies the client by its IP alone.
+func (z *UserHandler) IPKey(r *http.Request) string {
+	return "ip:" + z.ClientIP(r)
+}
+
+// ClientIP is the IP of the client. A request from a trusted proxy comes from
+// the last X-Forwarded-For address that isn't a trusted proxy, or from its
+// X-Real-IP; anyone else could forge these headers, so they are ignored.
+func (z *UserHandler) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !z.trustedProxy(ip) {
+		return ip
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !z.trustedProxy(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+func (z *UserHandler) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range z.config.TrustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 func (z *UserHandler) GetUserURLsHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := z.getTokenCookie(w, r)
 	if err != nil {
@@ -117,6 +267,27 @@ func (z *UserHandler) PingHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// StatsHandler is mounted behind the trusted subnet check.
+func (z *UserHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	res, err := z.userService.GetStats(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	responseBody, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, "can't serialize response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(responseBody)
+	if err != nil {
+		http.Error(w, "can't write response", http.StatusBadRequest)
+		return
+	}
+}
+
 func (z *UserHandler) DefaultHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusBadRequest)
 	_, err := w.Write([]byte("unsupported request type"))
@@ -141,10 +312,17 @@ func (z *UserHandler) PostMethodHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "body can't be empty", http.StatusBadRequest)
 		return
 	} else {
-		resURL, key, _ := z.userService.GetID(string(b))
+		resURL, key, err := z.userService.GetID(string(b), urls.LinkOptions{})
+		if writeValidationError(w, err) {
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 
-		err = z.userService.SaveUserURL(r.Context(), userID, string(b), key)
-		if errors.As(err, &urls.ErrDuplicateKey) {
+		err = z.userService.SaveUserURL(r.Context(), userID, string(b), key, urls.LinkOptions{})
+		if errors.Is(err, urls.ErrDuplicateKey) {
 			w.WriteHeader(http.StatusConflict)
 			_, err = w.Write([]byte(resURL))
 			if err != nil {
@@ -153,6 +331,9 @@ func (z *UserHandler) PostMethodHandler(w http.ResponseWriter, r *http.Request)
 			}
 			return
 		}
+		if writeQuotaError(w, err) {
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -187,7 +368,10 @@ func (z *UserHandler) PostShortenHandler(w http.ResponseWriter, r *http.Request)
 			http.Error(w, "json error", http.StatusBadRequest)
 			return
 		}
-		resURL, key, err := z.userService.GetID(req.URL)
+		resURL, key, err := z.userService.GetID(req.URL, req.LinkOptions)
+		if writeValidationError(w, err) {
+			return
+		}
 		if err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
 			return
@@ -200,7 +384,7 @@ func (z *UserHandler) PostShortenHandler(w http.ResponseWriter, r *http.Request)
 		}
 		w.Header().Set("Content-Type", "application/json")
 
-		err = z.userService.SaveUserURL(r.Context(), userID, req.URL, key)
+		err = z.userService.SaveUserURL(r.Context(), userID, req.URL, key, req.LinkOptions)
 		if errors.Is(err, urls.ErrDuplicateKey) {
 			w.WriteHeader(http.StatusConflict)
 			_, err = w.Write(responseBody)
@@ -210,6 +394,9 @@ func (z *UserHandler) PostShortenHandler(w http.ResponseWriter, r *http.Request)
 			}
 			return
 		}
+		if writeQuotaError(w, err) {
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -243,10 +430,16 @@ func (z *UserHandler) PostShortenBatchHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 	result, err := z.userService.SaveBatch(r.Context(), userID, req)
-	if errors.As(err, &urls.ErrDuplicateKey) {
+	if writeValidationError(w, err) {
+		return
+	}
+	if errors.Is(err, urls.ErrDuplicateKey) {
 		w.WriteHeader(http.StatusConflict)
 		return
 	}
+	if writeQuotaError(w, err) {
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -267,6 +460,15 @@ func (z *UserHandler) PostShortenBatchHandler(w http.ResponseWriter, r *http.Req
 	}
 }
 
+// requestKey is the short code in the path of r, without the query.
+func requestKey(r *http.Request) string {
+	key := r.RequestURI[1:]
+	if i := strings.IndexByte(key, '?'); i >= 0 {
+		key = key[:i]
+	}
+	return key
+}
+
 func (z *UserHandler) GetMethodHandler(w http.ResponseWriter, r *http.Request) {
 	if r.RequestURI == "" || r.RequestURI[1:] == "" {
 		http.Error(w, "bad request", http.StatusBadRequest)
@@ -277,23 +479,99 @@ func (z *UserHandler) GetMethodHandler(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		key := r.RequestURI[1:]
-		userID := ""
-		res, err := z.userService.GetURLByShort(r.Context(), userID, key)
+		key := requestKey(r)
+		if strings.HasSuffix(key, qrSuffix) {
+			z.writeQR(w, r, strings.TrimSuffix(key, qrSuffix))
+			return
+		}
+		if r.URL.Query().Get(previewParam) == "1" {
+			z.writePreview(w, r, key)
+			return
+		}
+		target, err := z.userService.Resolve(r.Context(), key)
 		if errors.Is(err, urls.ErrNotFound) {
+			// a code may itself end with "+", so the preview suffix is only
+			// tried once the code is not found as is
+			if strings.HasSuffix(key, previewSuffix) {
+				z.writePreview(w, r, strings.TrimSuffix(key, previewSuffix))
+				return
+			}
 			w.WriteHeader(http.StatusGone)
 			return
 		}
+		var blockedErr *urls.BlockedError
+		if errors.As(err, &blockedErr) {
+			z.writeInterstitial(w, r, blockedErr.URL, blockedErr.Rule)
+			return
+		}
+		var notActiveErr *urls.NotActiveError
+		if errors.As(err, &notActiveErr) {
+			z.writeNotActive(w, r, notActiveErr.ActiveFrom)
+			return
+		}
 		if err != nil {
 			http.Error(w, "url was not found", http.StatusBadRequest)
 			return
 		}
-		w.Header().Set("Location", res)
-		w.WriteHeader(http.StatusTemporaryRedirect)
+		if !z.unlocked(r, key, target.PasswordHash) {
+			z.writePasswordForm(w, r, http.StatusOK, "")
+			return
+		}
+		if target.Limited {
+			// the click is taken before the redirect, so a link that has
+			// none left answers like a deleted one
+			err = z.userService.TakeClick(r.Context(), key)
+			if errors.Is(err, urls.ErrNotFound) {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		} else {
+			z.userService.RecordClick(r.Context(), key)
+		}
+		z.redirect(w, r, target)
 		return
 	}
 }
 
+// UpdateLinkHandler serves PUT /api/user/urls/{id}. The body replaces all
+// options of the link, so omitted ones are reset; only the password and the
+// clicks left are kept unless password or max_clicks is given. "password": ""
+// and "max_clicks": 0 remove them.
+func (z *UserHandler) UpdateLinkHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := getRequestBody(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	userID, err := z.getTokenCookie(w, r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var opts urls.LinkOptions
+	if err := json.Unmarshal(b, &opts); err != nil {
+		http.Error(w, "json error", http.StatusBadRequest)
+		return
+	}
+	err = z.userService.UpdateLink(r.Context(), userID, chi.URLParam(r, "id"), opts)
+	if errors.Is(err, urls.ErrNotFound) {
+		http.Error(w, "url was not found", http.StatusNotFound)
+		return
+	}
+	if writeValidationError(w, err) {
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (z *UserHandler) AsyncDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := getRequestBody(r)
 	if err != nil {
@@ -313,9 +591,45 @@ func (z *UserHandler) AsyncDeleteHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	err = z.DeleteService.DeleteBatch(r.Context(), userID, req)
+	if writeQuotaError(w, err) {
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// writeValidationError answers 400 with the reason if err is a rejected URL
+// and 403 if the URL is blocklisted.
+func writeValidationError(w http.ResponseWriter, err error) bool {
+	var blockedErr *urls.BlockedError
+	if errors.As(err, &blockedErr) {
+		http.Error(w, blockedErr.Error(), http.StatusForbidden)
+		return true
+	}
+	var validationErr *urls.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+	http.Error(w, validationErr.Error(), http.StatusBadRequest)
+	return true
+}
+
+// writeQuotaError answers 403 with the usage and the limit if err is a quota error.
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	var quotaErr *urls.QuotaError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	responseBody, err := json.Marshal(quotaErr)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = w.Write(responseBody)
+	return true
+}
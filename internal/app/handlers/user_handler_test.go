@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -116,6 +119,27 @@ func TestUserHandler_getTokenCookieHeader(t *testing.T) {
 	}
 }
 
+func TestUserHandler_makeCookieSecure(t *testing.T) {
+	tests := []struct {
+		name   string
+		secure bool
+	}{
+		{name: "Test 1. Plain HTTP cookie.", secure: false},
+		{name: "Test 2. HTTPS cookie.", secure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewUserHandler(userService, userHandler.cryptoService, deleteService, zap.NewNop(), Config{CookieSecure: tt.secure})
+			w := httptest.NewRecorder()
+			_, err := h.getTokenCookie(w, httptest.NewRequest("GET", "/user/urls", nil))
+			assert.NoError(t, err)
+			cookies := w.Result().Cookies()
+			assert.Len(t, cookies, 1)
+			assert.Equal(t, tt.secure, cookies[0].Secure)
+		})
+	}
+}
+
 func TestUserHandler_PostShortenBatchHandler(t *testing.T) {
 	type args struct {
 		requestBody string
@@ -181,6 +205,14 @@ func TestUserHandler_PostMethodHandler(t *testing.T) {
 			args:  args{requestBody: "bad_URL"},
 			wants: wants{responseCode: http.StatusConflict, resultResponse: ""},
 		},
+		{name: "Test 4. Invalid URL.",
+			args:  args{requestBody: "javascript:alert(1)"},
+			wants: wants{responseCode: http.StatusBadRequest, resultResponse: "invalid url: scheme \"javascript\" is not allowed\n"},
+		},
+		{name: "Test 5. Blocklisted URL.",
+			args:  args{requestBody: "https://evil.example/"},
+			wants: wants{responseCode: http.StatusForbidden, resultResponse: "url is blocklisted: matches evil.example\n"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -193,7 +225,7 @@ func TestUserHandler_PostMethodHandler(t *testing.T) {
 
 			assert.Equal(t, tt.wants.responseCode, res.StatusCode, "Expected status %d, got %d", tt.wants.responseCode, res.StatusCode)
 
-			if res.StatusCode == http.StatusCreated {
+			if tt.wants.resultResponse != "" {
 				responseBody, err := io.ReadAll(res.Body)
 				defer res.Body.Close()
 				if err != nil {
@@ -359,6 +391,170 @@ func TestUserHandler_getMethodHandler(t *testing.T) {
 	}
 }
 
+func TestUserHandler_redirectType(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       Config
+		key          string
+		wantCode     int
+		cacheControl string
+	}{
+		{name: "Test 1. Default.", key: "short_URL", wantCode: http.StatusTemporaryRedirect, cacheControl: "private, no-cache"},
+		{name: "Test 2. Global setting.", config: Config{RedirectType: http.StatusMovedPermanently}, key: "short_URL",
+			wantCode: http.StatusMovedPermanently, cacheControl: "private, max-age=300"},
+		{name: "Test 3. Link setting wins.", config: Config{RedirectType: http.StatusFound}, key: "permanent_URL",
+			wantCode: http.StatusPermanentRedirect, cacheControl: "private, max-age=300"},
+	}
+	cryptoService := new(CryptoServiceMock)
+	cryptoService.On("GetNewUserToken").Return("user_id", "valid_user_Token", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewUserHandler(userService, cryptoService, deleteService, zap.NewNop(), tt.config)
+			w := httptest.NewRecorder()
+			h.GetMethodHandler(w, httptest.NewRequest(http.MethodGet, "/"+tt.key, nil))
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.wantCode, res.StatusCode)
+			assert.Equal(t, "original_URL", res.Header.Get("Location"))
+			assert.Equal(t, tt.cacheControl, res.Header.Get("Cache-Control"))
+		})
+	}
+}
+
+func TestUserHandler_maxClicks(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		wantCode int
+		location string
+	}{
+		{name: "Test 1. Clicks left.", key: "limited_URL", wantCode: http.StatusTemporaryRedirect, location: "original_URL"},
+		{name: "Test 2. No clicks left.", key: "used_URL", wantCode: http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			userHandler.GetMethodHandler(w, httptest.NewRequest(http.MethodGet, "/"+tt.key, nil))
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.wantCode, res.StatusCode)
+			assert.Equal(t, tt.location, res.Header.Get("Location"))
+			userService.AssertCalled(t, "TakeClick", tt.key)
+			userService.AssertNotCalled(t, "RecordClick", tt.key)
+		})
+	}
+}
+
+func TestUserHandler_notActive(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		wantCode int
+	}{
+		{name: "Test 1. Default status.", wantCode: http.StatusForbidden},
+		{name: "Test 2. Configured status.", config: Config{NotActiveStatus: http.StatusNotFound}, wantCode: http.StatusNotFound},
+	}
+	cryptoService := new(CryptoServiceMock)
+	cryptoService.On("GetNewUserToken").Return("user_id", "valid_user_Token", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewUserHandler(userService, cryptoService, deleteService, zap.NewNop(), tt.config)
+			w := httptest.NewRecorder()
+			h.GetMethodHandler(w, httptest.NewRequest(http.MethodGet, "/early_URL", nil))
+			res := w.Result()
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tt.wantCode, res.StatusCode)
+			assert.Empty(t, res.Header.Get("Location"))
+			assert.Equal(t, "Tue, 01 Jan 2030 09:00:00 GMT", res.Header.Get("Retry-After"))
+			assert.Contains(t, string(body), "2030-01-01 09:00 UTC")
+		})
+	}
+}
+
+func TestUserHandler_UpdateLinkHandler(t *testing.T) {
+	r := chi.NewRouter()
+	r.Put("/api/user/urls/{id}", userHandler.UpdateLinkHandler)
+	tests := []struct {
+		name     string
+		id       string
+		body     string
+		wantCode int
+	}{
+		{name: "Test 1. Update.", id: "short_URL", body: `{"title":"New","redirect_type":301}`, wantCode: http.StatusNoContent},
+		{name: "Test 2. Invalid redirect type.", id: "short_URL", body: `{"redirect_type":303}`, wantCode: http.StatusBadRequest},
+		{name: "Test 3. Not the owner or unknown.", id: "badURL", body: `{}`, wantCode: http.StatusNotFound},
+		{name: "Test 4. Bad JSON.", id: "short_URL", body: `{`, wantCode: http.StatusBadRequest},
+		{name: "Test 5. Activation window.", id: "window_URL", body: `{"active_from":"2022-06-01T09:00:00Z"}`, wantCode: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPut, "/api/user/urls/"+tt.id, strings.NewReader(tt.body))
+			request.AddCookie(&http.Cookie{Name: "token", Value: "user_id"})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantCode, res.StatusCode)
+		})
+	}
+}
+
+func TestUserHandler_getMethodHandlerBlocked(t *testing.T) {
+	request := httptest.NewRequest("GET", "/blocked_URL", nil)
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(userHandler.GetMethodHandler)
+	h.ServeHTTP(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "", res.Header.Get("Location"), "blocklisted link must not redirect")
+	assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "evil.example")
+	assert.Contains(t, string(body), "https://evil.example/?q=%3cb%3e")
+	assert.NotContains(t, string(body), "<b>")
+}
+
+func TestUserHandler_preview(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		responseCode int
+		wantBody     []string
+	}{
+		{name: "Test 1. Preview suffix.", target: "/short_URL+", responseCode: http.StatusOK,
+			wantBody: []string{"Owner&#39;s title", "http://localhost:8080/short_URL", "http://example.com/?a=1&amp;b=&lt;2&gt;", "2022-05-01 12:30 UTC", "<dd>42</dd>"}},
+		{name: "Test 2. Preview parameter.", target: "/short_URL?preview=1", responseCode: http.StatusOK,
+			wantBody: []string{"Owner&#39;s title"}},
+		{name: "Test 3. Unknown code.", target: "/badURL+", responseCode: http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+			h := http.HandlerFunc(userHandler.GetMethodHandler)
+			h.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.responseCode, res.StatusCode)
+			assert.Equal(t, "", res.Header.Get("Location"), "preview must not redirect")
+			body, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			for _, want := range tt.wantBody {
+				assert.Contains(t, string(body), want)
+			}
+		})
+	}
+}
+
 func TestUserHandler_DefaultHandler(t *testing.T) {
 	type args struct {
 		method string
@@ -408,3 +604,105 @@ func TestUserHandler_DefaultHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestUserHandler_StatsHandler(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+	w := httptest.NewRecorder()
+	http.HandlerFunc(userHandler.StatsHandler).ServeHTTP(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{"urls": 12, "users": 3}`, string(body))
+}
+
+func TestUserHandler_ClientKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+		want   []string
+	}{
+		{name: "Test 1. Valid token.", cookie: "user_id", want: []string{"ip:192.0.2.1", "user:user_id"}},
+		{name: "Test 2. No token.", cookie: "", want: []string{"ip:192.0.2.1"}},
+		{name: "Test 3. Invalid token.", cookie: "forged", want: []string{"ip:192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+			if tt.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: "token", Value: tt.cookie})
+			}
+			assert.Equal(t, tt.want, userHandler.ClientKeys(request))
+		})
+	}
+}
+
+func TestUserHandler_ClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	h := NewUserHandler(userService, nil, deleteService, zap.NewNop(), Config{TrustedProxies: []*net.IPNet{proxies}})
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{name: "Test 1. Direct client.", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "Test 2. Forged header from a client.", remoteAddr: "192.0.2.1:1234", forwarded: []string{"198.51.100.7"}, want: "192.0.2.1"},
+		{name: "Test 3. Behind a proxy.", remoteAddr: "10.0.0.2:1234", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "Test 4. Client prepends a forged hop.", remoteAddr: "10.0.0.2:1234", forwarded: []string{"203.0.113.9, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "Test 5. Chain of proxies.", remoteAddr: "10.0.0.2:1234", forwarded: []string{"198.51.100.7, 10.0.0.5", "10.0.0.3"}, want: "198.51.100.7"},
+		{name: "Test 6. Garbage hop.", remoteAddr: "10.0.0.2:1234", forwarded: []string{"198.51.100.7, unknown"}, want: "10.0.0.2"},
+		{name: "Test 7. X-Real-IP.", remoteAddr: "10.0.0.2:1234", realIP: "198.51.100.7", want: "198.51.100.7"},
+		{name: "Test 8. Proxy without headers.", remoteAddr: "10.0.0.2:1234", want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/short_URL", nil)
+			request.RemoteAddr = tt.remoteAddr
+			for _, f := range tt.forwarded {
+				request.Header.Add("X-Forwarded-For", f)
+			}
+			if tt.realIP != "" {
+				request.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.want, h.ClientIP(request))
+		})
+	}
+}
+
+func TestUserHandler_Quota(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		handler  http.HandlerFunc
+		response string
+	}{
+		{name: "Test 1. Too many links.", method: http.MethodPost, target: "/api/shorten/batch",
+			body:     `[{"correlation_id": "over", "original_url": "quota_URL"}]`,
+			handler:  userHandler.PostShortenBatchHandler,
+			response: `{"quota": "links", "usage": 10, "limit": 10}`,
+		},
+		{name: "Test 2. Delete request too large.", method: http.MethodDelete, target: "/api/user/urls",
+			body:     `["a", "b", "c"]`,
+			handler:  userHandler.AsyncDeleteHandler,
+			response: `{"quota": "delete_size", "usage": 3, "limit": 2}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			request.AddCookie(&http.Cookie{Name: "token", Value: "user_id"})
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+			assert.JSONEq(t, tt.response, string(body))
+		})
+	}
+}
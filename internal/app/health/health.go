@@ -0,0 +1,137 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+const defaultCheckTimeout = 2 * time.Second
+
+type CheckFunc func(ctx context.Context) error
+
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type namedCheck struct {
+	name string
+	fn   CheckFunc
+}
+
+// Health serves the liveness and readiness probes. Readiness is off until
+// SetReady(true) and is switched off first on shutdown, so load balancers
+// stop sending traffic before connections are closed.
+type Health struct {
+	sync.Mutex
+	checks  []namedCheck
+	ready   int32
+	timeout time.Duration
+}
+
+func NewHealth() *Health {
+	var h Health
+	h.timeout = defaultCheckTimeout
+	return &h
+}
+
+func (h *Health) AddCheck(name string, fn CheckFunc) {
+	h.Lock()
+	defer h.Unlock()
+	h.checks = append(h.checks, namedCheck{name: name, fn: fn})
+}
+
+func (h *Health) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&h.ready, v)
+}
+
+func (h *Health) Ready() bool {
+	return atomic.LoadInt32(&h.ready) == 1
+}
+
+// Check runs all checks in parallel, each with its own timeout.
+func (h *Health) Check(ctx context.Context) Report {
+	h.Lock()
+	checks := make([]namedCheck, len(h.checks))
+	copy(checks, h.checks)
+	h.Unlock()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+			start := time.Now()
+			err := c.fn(checkCtx)
+			res := CheckResult{Status: StatusOK, Duration: time.Since(start).String()}
+			if err != nil {
+				res.Status = StatusFail
+				res.Error = err.Error()
+			}
+			results[i] = res
+		}(i, c)
+	}
+	wg.Wait()
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// LivenessHandler answers 200 while the process is able to serve requests.
+func (h *Health) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": StatusOK})
+}
+
+// ReadinessHandler answers 200 with a per-dependency report, or 503 when a
+// check fails or the server is shutting down.
+func (h *Health) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.Ready() {
+		writeJSON(w, http.StatusServiceUnavailable, Report{Status: StatusFail, Checks: map[string]CheckResult{}})
+		return
+	}
+	report := h.Check(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	responseBody, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "can't serialize response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(responseBody)
+}
@@ -0,0 +1,72 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth_ReadinessHandler(t *testing.T) {
+	type wants struct {
+		responseCode int
+		status       string
+		checks       map[string]string
+	}
+	tests := []struct {
+		name     string
+		ready    bool
+		queueErr error
+		wants    wants
+	}{
+		{name: "Test 1. Ready.",
+			ready: true,
+			wants: wants{responseCode: http.StatusOK, status: StatusOK, checks: map[string]string{"database": StatusOK, "delete_queue": StatusOK}},
+		},
+		{name: "Test 2. Dependency fails.",
+			ready:    true,
+			queueErr: errors.New("delete queue is saturated"),
+			wants:    wants{responseCode: http.StatusServiceUnavailable, status: StatusFail, checks: map[string]string{"database": StatusOK, "delete_queue": StatusFail}},
+		},
+		{name: "Test 3. Shutting down.",
+			ready: false,
+			wants: wants{responseCode: http.StatusServiceUnavailable, status: StatusFail, checks: map[string]string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealth()
+			h.AddCheck("database", func(ctx context.Context) error { return nil })
+			h.AddCheck("delete_queue", func(ctx context.Context) error { return tt.queueErr })
+			h.SetReady(tt.ready)
+
+			w := httptest.NewRecorder()
+			h.ReadinessHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.wants.responseCode, res.StatusCode)
+			var report Report
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&report))
+			assert.Equal(t, tt.wants.status, report.Status)
+			got := make(map[string]string)
+			for name, c := range report.Checks {
+				got[name] = c.Status
+			}
+			assert.Equal(t, tt.wants.checks, got)
+		})
+	}
+}
+
+func TestHealth_LivenessHandler(t *testing.T) {
+	h := NewHealth()
+	h.AddCheck("database", func(ctx context.Context) error { return errors.New("down") })
+	w := httptest.NewRecorder()
+	h.LivenessHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
@@ -0,0 +1,116 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-Id"
+
+const redacted = "[REDACTED]"
+
+type ctxKey struct{}
+
+// NewLogger builds a JSON logger writing to stdout at the given level
+// (debug, info, warn, error). The returned level can be changed at runtime.
+func NewLogger(level string) (*zap.Logger, zap.AtomicLevel, error) {
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, zap.AtomicLevel{}, err
+	}
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	cfg.OutputPaths = []string{"stdout"}
+	cfg.EncoderConfig.TimeKey = "time"
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	log, err := cfg.Build()
+	if err != nil {
+		return nil, zap.AtomicLevel{}, err
+	}
+	return log, cfg.Level, nil
+}
+
+// For returns log tagged with the request ID and trace ID carried by ctx, if any.
+func For(ctx context.Context, log *zap.Logger) *zap.Logger {
+	if id := RequestID(ctx); id != "" {
+		log = log.With(zap.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		log = log.With(zap.String("trace_id", sc.TraceID().String()))
+	}
+	return log
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Redact hides a secret (token, password) but keeps a hint of whether it was set.
+func Redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// NewRequestID returns a random 16-character hex ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestIDMiddleware takes the request ID from the X-Request-Id header or
+// generates one, puts it into the request context and echoes it back.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// Middleware writes one access line per request. Headers and cookies are not
+// logged, so tokens don't end up in the log.
+func Middleware(log *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			route := ""
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+			For(r.Context(), log).Info("request",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("route", route),
+				zap.Int("status", ww.Status()),
+				zap.Int("bytes", ww.BytesWritten()),
+				zap.Duration("duration", time.Since(start)),
+				zap.String("remote_addr", r.RemoteAddr),
+			)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package logger
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantSame bool
+	}{
+		{name: "Test 1. Request ID from header.", header: "abc", wantSame: true},
+		{name: "Test 2. Generated request ID.", header: "", wantSame: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = RequestID(r.Context())
+			}))
+			request := httptest.NewRequest("GET", "/", nil)
+			request.Header.Set(RequestIDHeader, tt.header)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, request)
+
+			assert.NotEmpty(t, got)
+			assert.Equal(t, got, w.Header().Get(RequestIDHeader))
+			if tt.wantSame {
+				assert.Equal(t, tt.header, got)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	h := RequestIDMiddleware(Middleware(zap.New(core))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})))
+	request := httptest.NewRequest("POST", "/api/shorten", nil)
+	request.Header.Set(RequestIDHeader, "req-1")
+	request.AddCookie(&http.Cookie{Name: "token", Value: "secret"})
+	h.ServeHTTP(httptest.NewRecorder(), request)
+
+	entries := logs.All()
+	assert.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "req-1", fields["request_id"])
+	assert.Equal(t, int64(http.StatusCreated), fields["status"])
+	for _, v := range fields {
+		if str, ok := v.(string); ok {
+			assert.NotContains(t, str, "secret")
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "", Redact(""))
+	assert.Equal(t, "[REDACTED]", Redact("token"))
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "shortener"
+
+type DeleteServiceStats interface {
+	QueueDepth() int
+	ActiveWorkers() int
+	Failures() uint64
+}
+
+type PoolStats interface {
+	Stat() *pgxpool.Stat
+}
+
+type FileStorageStats interface {
+	Len() int
+}
+
+type CacheStats interface {
+	Hits() uint64
+	Misses() uint64
+}
+
+type Metrics struct {
+	registry  *prometheus.Registry
+	requests  *prometheus.CounterVec
+	latency   *prometheus.HistogramVec
+	redirects *prometheus.CounterVec
+	shortens  *prometheus.CounterVec
+}
+
+// NewMetrics registers the HTTP metrics in reg. Tests pass their own registry
+// and assert on it.
+func NewMetrics(reg *prometheus.Registry) *Metrics {
+	var m Metrics
+	m.registry = reg
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+	m.latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	m.redirects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Short link resolutions by result: hit, page (preview or warning), miss or gone.",
+	}, []string{"result"})
+	m.shortens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shorten_requests_total",
+		Help:      "Shorten requests by result: created or conflict.",
+	}, []string{"result"})
+	reg.MustRegister(m.requests, m.latency, m.redirects, m.shortens)
+	return &m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records request metrics under the chi route pattern, so that
+// /{id} is one series and not one per short code.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		m.latency.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		m.observeResult(route, r.Method, status)
+	})
+}
+
+func (m *Metrics) observeResult(route string, method string, status int) {
+	switch {
+	case method == http.MethodGet && route == "/{id}":
+		switch status {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			m.redirects.WithLabelValues("hit").Inc()
+		case http.StatusOK:
+			m.redirects.WithLabelValues("page").Inc()
+		case http.StatusGone:
+			m.redirects.WithLabelValues("gone").Inc()
+		default:
+			m.redirects.WithLabelValues("miss").Inc()
+		}
+	case method == http.MethodPost && (route == "/" || route == "/api/shorten" || route == "/api/shorten/batch"):
+		switch status {
+		case http.StatusCreated:
+			m.shortens.WithLabelValues("created").Inc()
+		case http.StatusConflict:
+			m.shortens.WithLabelValues("conflict").Inc()
+		}
+	}
+}
+
+func (m *Metrics) WatchDeleteService(s DeleteServiceStats) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "delete_queue_depth",
+			Help:      "Delete jobs waiting for a worker.",
+		}, func() float64 { return float64(s.QueueDepth()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "delete_active_workers",
+			Help:      "Delete workers running a job.",
+		}, func() float64 { return float64(s.ActiveWorkers()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "delete_failures_total",
+			Help:      "Delete jobs that failed.",
+		}, func() float64 { return float64(s.Failures()) }),
+	)
+}
+
+func (m *Metrics) WatchFileStorage(s FileStorageStats) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "file_storage_records",
+		Help:      "Records held by the file storage.",
+	}, func() float64 { return float64(s.Len()) }))
+}
+
+func (m *Metrics) WatchURLCache(s CacheStats) {
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "url_cache_hits_total",
+			Help:      "Short code lookups served from the cache.",
+		}, func() float64 { return float64(s.Hits()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "url_cache_misses_total",
+			Help:      "Short code lookups that went to the database.",
+		}, func() float64 { return float64(s.Misses()) }),
+	)
+}
+
+func (m *Metrics) WatchPool(s PoolStats) {
+	m.registry.MustRegister(newPoolCollector(s))
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type deleteServiceStub struct{}
+
+func (deleteServiceStub) QueueDepth() int    { return 3 }
+func (deleteServiceStub) ActiveWorkers() int { return 2 }
+func (deleteServiceStub) Failures() uint64   { return 1 }
+
+func statusHandler(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}
+}
+
+func TestMetrics_Middleware(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(reg)
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "badURL" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	router.Post("/api/shorten", statusHandler(http.StatusConflict))
+
+	for _, path := range []string{"/a", "/b", "/badURL"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/shorten", nil))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues("/{id}", "GET", "307")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.redirects.WithLabelValues("hit")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.redirects.WithLabelValues("gone")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.shortens.WithLabelValues("conflict")))
+	count, err := testutil.GatherAndCount(reg, "shortener_http_request_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := NewMetrics(prometheus.NewRegistry())
+	m.WatchDeleteService(deleteServiceStub{})
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	res := w.Result()
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, strings.Contains(string(body), "shortener_delete_queue_depth 3"))
+	assert.True(t, strings.Contains(string(body), "shortener_delete_active_workers 2"))
+	assert.True(t, strings.Contains(string(body), "shortener_delete_failures_total 1"))
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector exports one pgxpool.Stat snapshot per scrape.
+type poolCollector struct {
+	source          PoolStats
+	acquiredConns   *prometheus.Desc
+	idleConns       *prometheus.Desc
+	totalConns      *prometheus.Desc
+	maxConns        *prometheus.Desc
+	acquireCount    *prometheus.Desc
+	acquireDuration *prometheus.Desc
+	emptyAcquire    *prometheus.Desc
+	canceledAcquire *prometheus.Desc
+}
+
+func newPoolCollector(source PoolStats) *poolCollector {
+	var c poolCollector
+	c.source = source
+	c.acquiredConns = poolDesc("acquired_conns", "Connections currently in use.")
+	c.idleConns = poolDesc("idle_conns", "Idle connections.")
+	c.totalConns = poolDesc("total_conns", "Open connections.")
+	c.maxConns = poolDesc("max_conns", "Maximum size of the pool.")
+	c.acquireCount = poolDesc("acquire_total", "Successful connection acquires.")
+	c.acquireDuration = poolDesc("acquire_duration_seconds_total", "Time spent acquiring connections.")
+	c.emptyAcquire = poolDesc("empty_acquire_total", "Acquires that had to wait for a connection.")
+	c.canceledAcquire = poolDesc("canceled_acquire_total", "Acquires cancelled by their context.")
+	return &c
+}
+
+func poolDesc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquiredConns
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+	ch <- c.acquireCount
+	ch <- c.acquireDuration
+	ch <- c.emptyAcquire
+	ch <- c.canceledAcquire
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.source.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, s.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquire, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquire, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+}
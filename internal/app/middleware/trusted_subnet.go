@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+)
+
+// TrustedSubnet lets a request through only if its client IP is inside cidr.
+// clientIP resolves the IP, trusting X-Real-IP and X-Forwarded-For only from
+// trusted proxies; when it is nil the IP is the peer of the connection.
+// Everything is rejected while cidr is empty.
+func TrustedSubnet(cidr string, clientIP func(r *http.Request) string) func(next http.Handler) http.Handler {
+	var subnet *net.IPNet
+	if cidr != "" {
+		_, subnet, _ = net.ParseCIDR(cidr)
+	}
+	if clientIP == nil {
+		clientIP = remoteIP
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := net.ParseIP(clientIP(r))
+			if subnet == nil || ip == nil || !subnet.Contains(ip) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedSubnet(t *testing.T) {
+	// the proxy at 10.0.0.1 tells the client IP in X-Real-IP
+	clientIP := func(r *http.Request) string {
+		if ip := remoteIP(r); ip != "10.0.0.1" {
+			return ip
+		}
+		return r.Header.Get("X-Real-IP")
+	}
+	tests := []struct {
+		name         string
+		subnet       string
+		clientIP     func(r *http.Request) string
+		remoteAddr   string
+		realIP       string
+		responseCode int
+	}{
+		{name: "Test 1. IP inside subnet.", subnet: "192.168.1.0/24", clientIP: clientIP, remoteAddr: "10.0.0.1:1234", realIP: "192.168.1.15", responseCode: http.StatusOK},
+		{name: "Test 2. IP outside subnet.", subnet: "192.168.1.0/24", clientIP: clientIP, remoteAddr: "10.0.0.1:1234", realIP: "10.0.0.2", responseCode: http.StatusForbidden},
+		{name: "Test 3. No header from the proxy.", subnet: "192.168.1.0/24", clientIP: clientIP, remoteAddr: "10.0.0.1:1234", realIP: "", responseCode: http.StatusForbidden},
+		{name: "Test 4. Subnet is not set.", subnet: "", clientIP: clientIP, remoteAddr: "10.0.0.1:1234", realIP: "192.168.1.15", responseCode: http.StatusForbidden},
+		{name: "Test 5. Header forged by a client.", subnet: "192.168.1.0/24", clientIP: clientIP, remoteAddr: "203.0.113.7:1234", realIP: "192.168.1.15", responseCode: http.StatusForbidden},
+		{name: "Test 6. Peer inside subnet.", subnet: "192.168.1.0/24", clientIP: clientIP, remoteAddr: "192.168.1.20:1234", realIP: "", responseCode: http.StatusOK},
+		{name: "Test 7. No resolver ignores the header.", subnet: "192.168.1.0/24", remoteAddr: "203.0.113.7:1234", realIP: "192.168.1.15", responseCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			request.RemoteAddr = tt.remoteAddr
+			request.Header.Set("X-Real-IP", tt.realIP)
+			w := httptest.NewRecorder()
+			TrustedSubnet(tt.subnet, tt.clientIP)(http.HandlerFunc(stubHandler)).ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.responseCode, res.StatusCode)
+		})
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"github.com/jackc/pgerrcode"
+	"time"
 )
 
 var UniqueViolation DatabaseError = DatabaseError{Code: pgerrcode.UniqueViolation}
 var NoRowFound DatabaseError = DatabaseError{Err: errors.New("no rows in result set")}
+var SharedLink DatabaseError = DatabaseError{Err: errors.New("link code is shared")}
 
 type FileRepository interface {
 	Find(key string) (string, error)
@@ -18,9 +20,17 @@ type FileRepository interface {
 type DBRepository interface {
 	FindByUser(ctx context.Context, userID string) ([]UserURLs, error)
 	FindByShort(ctx context.Context, userID string, shortURL string) (string, error)
-	Save(ctx context.Context, userID string, originalURL string, shortURL string) error
+	Save(ctx context.Context, userID string, e Element) error
 	SaveBatch(ctx context.Context, data UserBatchURLs) error
 	Ping(ctx context.Context) (bool, error)
+	GetStats(ctx context.Context) (*Stats, error)
+	CountByUser(ctx context.Context, userID string) (int, error)
+	FindLink(ctx context.Context, shortURL string) (*Link, error)
+	FindTarget(ctx context.Context, shortURL string) (*Target, error)
+	UpdateLink(ctx context.Context, userID string, e Element) error
+	// AddClicks adds the clicks counted per short code.
+	AddClicks(ctx context.Context, clicks map[string]int64) error
+	TakeClick(ctx context.Context, shortURL string) error
 }
 
 type UserURLs struct {
@@ -30,10 +40,73 @@ type UserURLs struct {
 	OriginalURL string
 }
 
+type Link struct {
+	ShortURL     string
+	OriginalURL  string
+	Title        string
+	CreatedAt    time.Time
+	Clicks       int64
+	PasswordHash string
+	// ClicksLeft is zero for a link without a click limit; a link that has
+	// used up its clicks is not found at all.
+	ClicksLeft  int64
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+}
+
+type Target struct {
+	OriginalURL      string
+	RedirectType     int
+	QueryPassthrough bool
+	UTM              UTM
+	PasswordHash     string
+	// Limited is set for a link with max_clicks; each redirect has to take
+	// one of its clicks.
+	Limited     bool
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+	Rules       []Rule
+}
+
+// Rule is stored as JSON in the rules column of the link.
+type Rule struct {
+	Platform string `json:"platform,omitempty"`
+	Language string `json:"language,omitempty"`
+	URL      string `json:"url"`
+}
+
+type UTM struct {
+	Source   string
+	Medium   string
+	Campaign string
+	Conflict string
+}
+
+type Stats struct {
+	URLs  int
+	Users int
+}
+
 type Element struct {
-	CorrelationID string
-	OriginalURL   string
-	ShortURL      string
+	CorrelationID    string
+	OriginalURL      string
+	ShortURL         string
+	Title            string
+	RedirectType     int
+	QueryPassthrough bool
+	UTM              UTM
+	PasswordHash     string
+	MaxClicks        int
+	ActiveFrom       *time.Time
+	ActiveUntil      *time.Time
+	Rules            []Rule
+	// OwnCode is set for a link with options: its code is random rather than
+	// made from the URL, and the URL may have other links too.
+	OwnCode bool
+	// KeepPassword and KeepClicks make UpdateLink leave the password and the
+	// clicks left of the link as they are.
+	KeepPassword bool
+	KeepClicks   bool
 }
 
 type UserBatchURLs struct {
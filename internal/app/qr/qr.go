@@ -0,0 +1,133 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/skip2/go-qrcode"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+const (
+	MaxSize   = 4096
+	MaxMargin = 64
+)
+
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Options control how a code is drawn. Size is the width of the image in
+// pixels, Margin the quiet zone around the code in modules and Level the
+// error correction level: L, M, Q or H.
+type Options struct {
+	Size   int
+	Margin int
+	Level  string
+}
+
+func (o Options) Validate() error {
+	if o.Size < 1 || o.Size > MaxSize {
+		return fmt.Errorf("size must be between 1 and %d, got %d", MaxSize, o.Size)
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return fmt.Errorf("margin must be between 0 and %d, got %d", MaxMargin, o.Margin)
+	}
+	if _, ok := levels[strings.ToUpper(o.Level)]; !ok {
+		return fmt.Errorf("level must be one of L, M, Q, H, got %q", o.Level)
+	}
+	return nil
+}
+
+// modules returns the code with its margin; true is a dark module.
+func (o Options) modules(content string) ([][]bool, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	code, err := qrcode.New(content, levels[strings.ToUpper(o.Level)])
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+	n := len(bitmap) + 2*o.Margin
+	res := make([][]bool, n)
+	for y := range res {
+		res[y] = make([]bool, n)
+		if y >= o.Margin && y < o.Margin+len(bitmap) {
+			copy(res[y][o.Margin:], bitmap[y-o.Margin])
+		}
+	}
+	return res, nil
+}
+
+// PNG draws the code Size pixels wide. Modules are whole pixels, so a Size
+// that is not a multiple of the module count is padded evenly; a Size below
+// the module count is raised to it.
+func PNG(content string, o Options) ([]byte, error) {
+	m, err := o.modules(content)
+	if err != nil {
+		return nil, err
+	}
+	n := len(m)
+	scale := o.Size / n
+	if scale < 1 {
+		scale = 1
+	}
+	size := o.Size
+	if size < n*scale {
+		size = n * scale
+	}
+	offset := (size - n*scale) / 2
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := range m {
+		for x, dark := range m[y] {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG draws the code in a viewBox of one unit per module, scaled to Size.
+func SVG(content string, o Options) ([]byte, error) {
+	m, err := o.modules(content)
+	if err != nil {
+		return nil, err
+	}
+	n := len(m)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, o.Size, o.Size, n, n)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y := range m {
+		for x := 0; x < n; x++ {
+			if !m[y][x] {
+				continue
+			}
+			run := 1
+			for x+run < n && m[y][x+run] {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x, y, run, run)
+			x += run - 1
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,66 @@
+package qr
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+const testURL = "http://localhost:8080/aHR0cDovL2V4YW1wbGUuY29t"
+
+func TestPNG(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		wantSize int
+	}{
+		{name: "Test 1. Default.", opts: Options{Size: 256, Margin: 4, Level: "M"}, wantSize: 256},
+		{name: "Test 2. Smaller than the code.", opts: Options{Size: 10, Margin: 0, Level: "l"}, wantSize: 29},
+		{name: "Test 3. Highest level.", opts: Options{Size: 300, Margin: 2, Level: "H"}, wantSize: 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := PNG(testURL, tt.opts)
+			assert.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(b))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSize, img.Bounds().Dx())
+			assert.Equal(t, tt.wantSize, img.Bounds().Dy())
+			r, _, _, _ := img.At(0, 0).RGBA()
+			if tt.opts.Margin > 0 {
+				assert.Equal(t, uint32(0xffff), r, "margin must be light")
+			}
+		})
+	}
+}
+
+func TestSVG(t *testing.T) {
+	b, err := SVG(testURL, Options{Size: 200, Margin: 4, Level: "Q"})
+	assert.NoError(t, err)
+	s := string(b)
+	assert.True(t, strings.HasPrefix(s, `<svg xmlns="http://www.w3.org/2000/svg" width="200" height="200" viewBox="0 0 41 41"`))
+	assert.Contains(t, s, `d="M4 4h7v1h-7z`, "finder pattern must start after the margin")
+	assert.True(t, strings.HasSuffix(s, `"/></svg>`))
+}
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "Test 1. Valid.", opts: Options{Size: 256, Margin: 4, Level: "M"}},
+		{name: "Test 2. Zero size.", opts: Options{Size: 0, Margin: 4, Level: "M"}, wantErr: true},
+		{name: "Test 3. Too large.", opts: Options{Size: MaxSize + 1, Margin: 4, Level: "M"}, wantErr: true},
+		{name: "Test 4. Negative margin.", opts: Options{Size: 256, Margin: -1, Level: "M"}, wantErr: true},
+		{name: "Test 5. Unknown level.", opts: Options{Size: 256, Margin: 4, Level: "X"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			assert.Equal(t, tt.wantErr, err != nil, "Validate() error = %v", err)
+		})
+	}
+}
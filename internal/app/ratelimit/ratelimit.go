@@ -0,0 +1,185 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limit is a token bucket: Rate tokens per second up to Burst. A zero Rate
+// means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Store keeps the buckets. MemoryStore is per process; a shared store lets
+// several replicas enforce one limit.
+type Store interface {
+	// Take removes a token from the bucket of key. When the bucket is empty it
+	// returns false and how long until the next token.
+	Take(key string, limit Limit) (bool, time.Duration)
+	// Give puts back a token Take has removed, up to the burst.
+	Give(key string, limit Limit)
+}
+
+// KeyFunc identifies the client a request is counted against. A request is
+// counted against each of the keys and let through only if none of them has
+// used up the limit.
+type KeyFunc func(r *http.Request) []string
+
+// Limiter limits requests per client and route class. Each class has its own
+// limit and its own buckets.
+type Limiter struct {
+	store  Store
+	key    KeyFunc
+	limits atomic.Value
+}
+
+func NewLimiter(store Store, key KeyFunc, limits map[string]Limit) *Limiter {
+	var l Limiter
+	l.store = store
+	l.key = key
+	l.SetLimits(limits)
+	return &l
+}
+
+// SetLimits replaces the limits of all classes; buckets are kept.
+func (l *Limiter) SetLimits(limits map[string]Limit) {
+	m := make(map[string]Limit, len(limits))
+	for k, v := range limits {
+		m[k] = v
+	}
+	l.limits.Store(m)
+}
+
+func (l *Limiter) limit(class string) Limit {
+	return l.limits.Load().(map[string]Limit)[class]
+}
+
+// Take counts a request of the client with keys against the limit of class.
+// The first key that has used up the limit stops the request: Take returns
+// false and how long until the next request is allowed.
+func (l *Limiter) Take(class string, keys ...string) (bool, time.Duration) {
+	limit := l.limit(class)
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	for _, key := range keys {
+		if ok, wait := l.store.Take(class+"|"+key, limit); !ok {
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// Give uncounts a request Take has let through, for requests that turn out
+// not to count, like a right password.
+func (l *Limiter) Give(class string, keys ...string) {
+	limit := l.limit(class)
+	if limit.Rate <= 0 {
+		return
+	}
+	for _, key := range keys {
+		l.store.Give(class+"|"+key, limit)
+	}
+}
+
+// RetryAfter formats a wait for the Retry-After header, in whole seconds.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// Middleware answers 429 with Retry-After once the client has used up the
+// limit of class.
+func (l *Limiter) Middleware(class string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l.limit(class).Rate <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ok, retryAfter := l.Take(class, l.key(r)...)
+			if !ok {
+				w.Header().Set("Retry-After", RetryAfter(retryAfter))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// MemoryStore keeps buckets in memory. Buckets that have refilled are dropped
+// from time to time, so idle clients don't pile up.
+type MemoryStore struct {
+	sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	var s MemoryStore
+	s.buckets = make(map[string]*bucket)
+	s.now = time.Now
+	s.lastSweep = s.now()
+	return &s
+}
+
+func (s *MemoryStore) Take(key string, limit Limit) (bool, time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) > sweepInterval {
+		s.sweep(now)
+	}
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (s *MemoryStore) Give(key string, limit Limit) {
+	s.Lock()
+	defer s.Unlock()
+	if b, exists := s.buckets[key]; exists {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+1)
+	}
+}
+
+func (s *MemoryStore) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.buckets)
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		ok, _ := s.Take("a", limit)
+		assert.True(t, ok, "burst must be allowed")
+	}
+	ok, retryAfter := s.Take("a", limit)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	ok, _ = s.Take("b", limit)
+	assert.True(t, ok, "buckets must be per key")
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = s.Take("a", limit)
+	assert.True(t, ok, "a token must be refilled after 1/rate")
+	ok, _ = s.Take("a", limit)
+	assert.False(t, ok)
+
+	now = now.Add(2 * sweepInterval)
+	s.Take("c", limit)
+	assert.Equal(t, 1, s.Len(), "refilled buckets must be swept")
+}
+
+func TestMemoryStore_Give(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 1}
+
+	s.Give("a", limit)
+	ok, _ := s.Take("a", limit)
+	assert.True(t, ok)
+	ok, _ = s.Take("a", limit)
+	assert.False(t, ok, "give must not add tokens to a bucket it didn't take from")
+
+	s.Give("a", limit)
+	ok, _ = s.Take("a", limit)
+	assert.True(t, ok, "a given back token must be taken again")
+	s.Give("a", limit)
+	s.Give("a", limit)
+	ok, _ = s.Take("a", limit)
+	assert.True(t, ok)
+	ok, _ = s.Take("a", limit)
+	assert.False(t, ok, "give must not go over the burst")
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		class     string
+		requests  int
+		wantCodes []int
+	}{
+		{name: "Test 1. Over the limit.", class: "shorten", requests: 3,
+			wantCodes: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}},
+		{name: "Test 2. Class without limit.", class: "redirect", requests: 3,
+			wantCodes: []int{http.StatusOK, http.StatusOK, http.StatusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(NewMemoryStore(), func(r *http.Request) []string { return []string{r.RemoteAddr} }, map[string]Limit{
+				"shorten": {Rate: 0.1, Burst: 2},
+			})
+			h := l.Middleware(tt.class)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			for i := 0; i < tt.requests; i++ {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten", nil))
+				res := w.Result()
+				res.Body.Close()
+				assert.Equal(t, tt.wantCodes[i], res.StatusCode)
+				if res.StatusCode == http.StatusTooManyRequests {
+					assert.Equal(t, "10", res.Header.Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
+
+func TestLimiter_SetLimits(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), func(r *http.Request) []string { return []string{"client"} }, map[string]Limit{
+		"batch": {Rate: 1, Burst: 1},
+	})
+	h := l.Middleware("batch")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+
+	l.SetLimits(map[string]Limit{})
+	assert.Equal(t, http.StatusOK, serve(), "removed limit must not apply any more")
+}
+
+func TestLimiter_TakeKeys(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), nil, map[string]Limit{
+		"shorten": {Rate: 0.1, Burst: 2},
+	})
+	ok, _ := l.Take("shorten", "ip:192.0.2.1", "user:a")
+	assert.True(t, ok)
+	ok, _ = l.Take("shorten", "ip:192.0.2.1", "user:b")
+	assert.True(t, ok)
+	// a fresh user doesn't bring a fresh limit for the same IP
+	ok, retryAfter := l.Take("shorten", "ip:192.0.2.1", "user:c")
+	assert.False(t, ok)
+	assert.Equal(t, "10", RetryAfter(retryAfter))
+	ok, _ = l.Take("shorten", "ip:192.0.2.2", "user:a")
+	assert.True(t, ok)
+	ok, _ = l.Take("shorten", "ip:192.0.2.3", "user:a")
+	assert.False(t, ok, "the user limit applies across IPs")
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
+	"github.com/da-semenov/go-short-url/internal/app/tracing"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// finalFlushTimeout bounds the flush of RunClickFlusher on shutdown.
+const finalFlushTimeout = 5 * time.Second
+
+// clickCounter adds up redirects per short code until they are flushed.
+type clickCounter struct {
+	sync.Mutex
+	counts map[string]int64
+}
+
+func (c *clickCounter) add(shortURL string, n int64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	c.counts[shortURL] += n
+}
+
+// take returns the counts so far and starts over.
+func (c *clickCounter) take() map[string]int64 {
+	c.Lock()
+	defer c.Unlock()
+	res := c.counts
+	c.counts = nil
+	return res
+}
+
+// FlushClicks writes the clicks recorded since the last flush in one batch.
+// On failure they are kept for the next flush.
+func (s *UserService) FlushClicks(ctx context.Context) error {
+	counts := s.clicks.take()
+	if len(counts) == 0 {
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "UserService.FlushClicks")
+	defer span.End()
+	err := s.dbRepository.AddClicks(ctx, counts)
+	if err != nil {
+		tracing.RecordError(span, err)
+		for shortURL, n := range counts {
+			s.clicks.add(shortURL, n)
+		}
+	}
+	return err
+}
+
+// RunClickFlusher flushes clicks every interval until ctx is done, then
+// flushes once more so the clicks of the last interval are not lost.
+func (s *UserService) RunClickFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.FlushClicks(ctx); err != nil {
+				logger.For(ctx, s.log).Warn("can't flush clicks", zap.Error(err))
+			}
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+			if err := s.FlushClicks(flushCtx); err != nil {
+				logger.For(ctx, s.log).Error("can't flush clicks on shutdown", zap.Error(err))
+			}
+			cancel()
+			return
+		}
+	}
+}
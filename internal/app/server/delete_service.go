@@ -2,9 +2,17 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
 	"github.com/da-semenov/go-short-url/internal/app/models"
+	"github.com/da-semenov/go-short-url/internal/app/tracing"
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	"math"
 	"sync"
+	"sync/atomic"
 )
 
 type pool struct {
@@ -38,20 +46,36 @@ func (p *pool) LessMax() bool {
 }
 
 type deleteJob struct {
-	UserID string
-	part   []string
+	UserID    string
+	part      []string
+	requestID string
+	parent    trace.SpanContext
 }
 
+var errDeleteClosed = errors.New("delete service is closed")
+
 type DeleteService struct {
 	pool         *pool
 	taskSize     int
+	maxURLs      int
 	dbRepository models.DeleteRepository
 	jobChanel    chan deleteJob
+	busy         int64
+	failures     uint64
+	log          *zap.Logger
+	// closeMu guards jobChanel against sends after Close
+	closeMu sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
 }
 
-func NewDeleteService(repoDB models.DeleteRepository, poolSize int, taskSize int) *DeleteService {
+// NewDeleteService starts poolSize workers deleting in parts of taskSize.
+// maxURLs caps the size of one request; zero means unlimited.
+func NewDeleteService(repoDB models.DeleteRepository, poolSize int, taskSize int, maxURLs int, log *zap.Logger) *DeleteService {
 	var s DeleteService
+	s.log = log
 	s.taskSize = taskSize
+	s.maxURLs = maxURLs
 	s.pool = newPool(poolSize)
 	s.dbRepository = repoDB
 	s.jobChanel = make(chan deleteJob, poolSize)
@@ -75,10 +99,20 @@ func split(batchSize int, src []string, resCh chan []string) {
 }
 
 func (s *DeleteService) DeleteBatch(ctx context.Context, userID string, URLList []string) error {
+	ctx, span := tracer.Start(ctx, "DeleteService.DeleteBatch")
+	defer span.End()
+	if s.maxURLs > 0 && len(URLList) > s.maxURLs {
+		return &urls.QuotaError{Quota: urls.QuotaDeleteSize, Usage: len(URLList), Limit: s.maxURLs}
+	}
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		return errDeleteClosed
+	}
 	chanel := make(chan []string)
 	go split(s.taskSize, URLList, chanel)
 	for part := range chanel {
-		s.jobChanel <- deleteJob{userID, part}
+		s.jobChanel <- deleteJob{userID, part, logger.RequestID(ctx), span.SpanContext()}
 	}
 	s.startWorkerPool()
 	return nil
@@ -87,16 +121,78 @@ func (s *DeleteService) DeleteBatch(ctx context.Context, userID string, URLList
 func (s *DeleteService) startWorkerPool() {
 	for s.pool.LessMax() {
 		s.pool.Inc()
+		s.workers.Add(1)
 		go func() {
+			defer s.workers.Done()
 			defer s.pool.Dec()
-			for {
-				for job := range s.jobChanel {
-					err := s.dbRepository.BatchDelete(context.Background(), job.UserID, job.part)
-					if err != nil {
-						panic(err)
-					}
-				}
+			for job := range s.jobChanel {
+				s.runJob(job)
 			}
 		}()
 	}
 }
+
+// Close stops taking deletes and waits until the queued ones are done, or
+// until ctx expires. The database must outlive it.
+func (s *DeleteService) Close(ctx context.Context) error {
+	s.closeMu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.jobChanel)
+	}
+	s.closeMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d delete jobs left in the queue: %w", len(s.jobChanel), ctx.Err())
+	}
+}
+
+func (s *DeleteService) runJob(job deleteJob) {
+	atomic.AddInt64(&s.busy, 1)
+	defer atomic.AddInt64(&s.busy, -1)
+	// The job outlives the request, so it gets its own trace linked to the
+	// span of the request that queued it.
+	ctx := logger.WithRequestID(context.Background(), job.requestID)
+	ctx, span := tracer.Start(ctx, "DeleteService.runJob",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.Link{SpanContext: job.parent}),
+	)
+	defer span.End()
+	err := s.dbRepository.BatchDelete(ctx, job.UserID, job.part)
+	if err != nil {
+		tracing.RecordError(span, err)
+		atomic.AddUint64(&s.failures, 1)
+		logger.For(ctx, s.log).Error("can't delete urls", zap.Int("count", len(job.part)), zap.Error(err))
+	}
+}
+
+// QueueDepth is the number of jobs waiting for a worker.
+func (s *DeleteService) QueueDepth() int {
+	return len(s.jobChanel)
+}
+
+// ActiveWorkers is the number of workers running a job right now.
+func (s *DeleteService) ActiveWorkers() int {
+	return int(atomic.LoadInt64(&s.busy))
+}
+
+// Failures is the number of jobs that failed since start.
+func (s *DeleteService) Failures() uint64 {
+	return atomic.LoadUint64(&s.failures)
+}
+
+// CheckQueue fails when the job queue is full and DeleteBatch would block.
+func (s *DeleteService) CheckQueue(ctx context.Context) error {
+	depth, capacity := len(s.jobChanel), cap(s.jobChanel)
+	if depth >= capacity {
+		return fmt.Errorf("delete queue is saturated: %d of %d", depth, capacity)
+	}
+	return nil
+}
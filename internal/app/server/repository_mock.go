@@ -42,8 +42,8 @@ func (r *DBRepositoryMock) FindByShort(ctx context.Context, userID string, short
 	return args.String(0), args.Error(1)
 }
 
-func (r *DBRepositoryMock) Save(ctx context.Context, userID string, originalURL string, shortURL string) error {
-	args := r.Called(userID, originalURL, shortURL)
+func (r *DBRepositoryMock) Save(ctx context.Context, userID string, e models.Element) error {
+	args := r.Called(userID, e)
 	return args.Error(0)
 }
 
@@ -56,3 +56,40 @@ func (r *DBRepositoryMock) Ping(ctx context.Context) (bool, error) {
 	args := r.Called()
 	return args.Bool(0), args.Error(1)
 }
+
+func (r *DBRepositoryMock) GetStats(ctx context.Context) (*models.Stats, error) {
+	args := r.Called()
+	return &models.Stats{URLs: args.Int(0), Users: args.Int(1)}, args.Error(2)
+}
+
+func (r *DBRepositoryMock) CountByUser(ctx context.Context, userID string) (int, error) {
+	args := r.Called(userID)
+	return args.Int(0), args.Error(1)
+}
+
+func (r *DBRepositoryMock) FindLink(ctx context.Context, shortURL string) (*models.Link, error) {
+	args := r.Called(shortURL)
+	res, _ := args.Get(0).(*models.Link)
+	return res, args.Error(1)
+}
+
+func (r *DBRepositoryMock) AddClicks(ctx context.Context, clicks map[string]int64) error {
+	args := r.Called(clicks)
+	return args.Error(0)
+}
+
+func (r *DBRepositoryMock) FindTarget(ctx context.Context, shortURL string) (*models.Target, error) {
+	args := r.Called(shortURL)
+	res, _ := args.Get(0).(*models.Target)
+	return res, args.Error(1)
+}
+
+func (r *DBRepositoryMock) UpdateLink(ctx context.Context, userID string, e models.Element) error {
+	args := r.Called(userID, e)
+	return args.Error(0)
+}
+
+func (r *DBRepositoryMock) TakeClick(ctx context.Context, shortURL string) error {
+	args := r.Called(shortURL)
+	return args.Error(0)
+}
@@ -2,23 +2,49 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"github.com/da-semenov/go-short-url/internal/app/logger"
 	"github.com/da-semenov/go-short-url/internal/app/models"
+	"github.com/da-semenov/go-short-url/internal/app/tracing"
 	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"regexp"
+	"strings"
+	"time"
 )
 
+var tracer = tracing.Tracer("github.com/da-semenov/go-short-url/internal/app/server")
+
 type EncodeFunc func(str string) string
 
+// Quotas are per-user limits; zero means unlimited.
+type Quotas struct {
+	MaxLinks     int
+	MaxBatchSize int
+}
+
 type UserService struct {
 	dbRepository   models.DBRepository
 	fileRepository models.FileRepository
 	encode         EncodeFunc
 	baseURL        string
+	quotas         Quotas
+	policy         URLPolicy
+	clicks         clickCounter
+	log            *zap.Logger
+	// passwordLinks is set once protected links can be unlocked
+	passwordLinks bool
 }
 
-func NewUserService(repoDB models.DBRepository, repoFile models.FileRepository, baseURL string) *UserService {
+func NewUserService(repoDB models.DBRepository, repoFile models.FileRepository, baseURL string, quotas Quotas, policy URLPolicy, log *zap.Logger) *UserService {
 	var s UserService
+	s.log = log
+	s.quotas = quotas
+	s.policy = policy
 	s.dbRepository = repoDB
 	s.fileRepository = repoFile
 	s.encode = func(str string) string {
@@ -28,24 +54,55 @@ func NewUserService(repoDB models.DBRepository, repoFile models.FileRepository,
 	return &s
 }
 
-func (s *UserService) GetID(url string) (string, string, error) {
-	if url == "" {
-		return "", "", errors.New("url is empty")
+// EnablePasswordLinks lets links be protected with a password. Until it is
+// called a password is rejected, as nobody could unlock the link.
+func (s *UserService) EnablePasswordLinks() {
+	s.passwordLinks = true
+}
+
+// GetID validates url and returns the short URL and key of a link with
+// opts. A plain link gets a key made from the URL as it will be stored, i.e.
+// normalized if the policy says so, and shares it with everyone who shortens
+// the URL. A link with options gets a random key of its own.
+func (s *UserService) GetID(url string, opts urls.LinkOptions) (string, string, error) {
+	url, err := s.policy.Check(url)
+	if err != nil {
+		return "", "", err
 	}
 	key := s.encode(url)
+	if !opts.IsZero() {
+		if key, err = randomCode(); err != nil {
+			return "", "", err
+		}
+	}
 	return s.baseURL + key, key, nil
 }
 
+// ownCodeBytes gives random codes 64 bits; they never clash with codes made
+// from URLs, which are padded to a multiple of 4 characters.
+const ownCodeBytes = 8
+
+func randomCode() (string, error) {
+	b := make([]byte, ownCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 func (s *UserService) mapUserURLs(src *models.UserURLs) (*urls.UserURLs, error) {
 	return &urls.UserURLs{ShortURL: s.baseURL + src.ShortURL, OriginalURL: src.OriginalURL}, nil
 }
 
 func (s *UserService) GetURLsByUser(ctx context.Context, userID string) ([]urls.UserURLs, error) {
+	ctx, span := tracer.Start(ctx, "UserService.GetURLsByUser")
+	defer span.End()
 	if userID == "" {
 		return nil, errors.New("user_id is empty")
 	}
 	resArr, err := s.dbRepository.FindByUser(ctx, userID)
 	if err != nil {
+		logger.For(ctx, s.log).Error("can't find urls by user", zap.Error(err))
 		return nil, err
 	}
 
@@ -60,38 +117,96 @@ func (s *UserService) GetURLsByUser(ctx context.Context, userID string) ([]urls.
 	return resList, nil
 }
 
-func (s *UserService) SaveUserURL(ctx context.Context, userID string, originalURL string, shortURL string) error {
-	err := s.fileRepository.Save(shortURL, originalURL)
+// checkLinksQuota fails if adding n links would take the user over MaxLinks.
+// Concurrent requests of one user may still overshoot it by a few links.
+func (s *UserService) checkLinksQuota(ctx context.Context, userID string, n int) error {
+	if s.quotas.MaxLinks <= 0 {
+		return nil
+	}
+	count, err := s.dbRepository.CountByUser(ctx, userID)
 	if err != nil {
+		logger.For(ctx, s.log).Error("can't count user urls", zap.Error(err))
 		return err
 	}
+	if count+n > s.quotas.MaxLinks {
+		return &urls.QuotaError{Quota: urls.QuotaLinks, Usage: count, Limit: s.quotas.MaxLinks}
+	}
+	return nil
+}
 
-	err = s.dbRepository.Save(ctx, userID, originalURL, shortURL)
+func (s *UserService) SaveUserURL(ctx context.Context, userID string, originalURL string, shortURL string, opts urls.LinkOptions) error {
+	ctx, span := tracer.Start(ctx, "UserService.SaveUserURL")
+	defer span.End()
+	originalURL, err := s.policy.Check(originalURL)
+	if err != nil {
+		return err
+	}
+	if err = s.checkOptions(opts); err != nil {
+		return err
+	}
+	if opts.Rules, err = s.checkRules(opts.Rules); err != nil {
+		return err
+	}
+	if err = s.checkLinksQuota(ctx, userID, 1); err != nil {
+		return err
+	}
+	err = s.fileRepository.Save(shortURL, originalURL)
+	if err != nil {
+		logger.For(ctx, s.log).Error("can't save url to file storage", zap.Error(err))
+		return err
+	}
+
+	e, err := linkElement(originalURL, shortURL, opts)
+	if err != nil {
+		return err
+	}
+	err = s.dbRepository.Save(ctx, userID, e)
 	if errors.Is(err, &models.UniqueViolation) {
 		return urls.ErrDuplicateKey
 	}
 	if err != nil {
+		logger.For(ctx, s.log).Error("can't save url", zap.Error(err))
 		return err
 	}
 	return nil
 }
 
 func (s *UserService) SaveBatch(ctx context.Context, userID string, src []urls.UserBatch) ([]urls.UserBatchResult, error) {
+	ctx, span := tracer.Start(ctx, "UserService.SaveBatch")
+	defer span.End()
 	var (
 		res     models.UserBatchURLs
 		err     error
 		resurls []urls.UserBatchResult
 	)
+	if s.quotas.MaxBatchSize > 0 && len(src) > s.quotas.MaxBatchSize {
+		return nil, &urls.QuotaError{Quota: urls.QuotaBatchSize, Usage: len(src), Limit: s.quotas.MaxBatchSize}
+	}
+	if err = s.checkLinksQuota(ctx, userID, len(src)); err != nil {
+		return nil, err
+	}
 	res.UserID = userID
 	for _, obj := range src {
-		var e models.Element
-		var fullShortURL string
-		e.CorrelationID = obj.CorrelationID
-		e.OriginalURL = obj.OriginalURL
-		fullShortURL, e.ShortURL, err = s.GetID(obj.OriginalURL)
+		var originalURL, fullShortURL, shortURL string
+		originalURL, err = s.policy.Check(obj.OriginalURL)
+		if err != nil {
+			return nil, err
+		}
+		if err = s.checkOptions(obj.LinkOptions); err != nil {
+			return nil, err
+		}
+		if obj.Rules, err = s.checkRules(obj.Rules); err != nil {
+			return nil, err
+		}
+		fullShortURL, shortURL, err = s.GetID(originalURL, obj.LinkOptions)
 		if err != nil {
 			return nil, err
 		}
+		e, err := linkElement(originalURL, shortURL, obj.LinkOptions)
+		if err != nil {
+			return nil, err
+		}
+		e.CorrelationID = obj.CorrelationID
 		res.List = append(res.List, e)
 		resurls = append(resurls, urls.UserBatchResult{CorrelationID: obj.CorrelationID, ShortURL: fullShortURL})
 	}
@@ -100,12 +215,15 @@ func (s *UserService) SaveBatch(ctx context.Context, userID string, src []urls.U
 		return nil, urls.ErrDuplicateKey
 	}
 	if err != nil {
+		logger.For(ctx, s.log).Error("can't save batch", zap.Int("count", len(res.List)), zap.Error(err))
 		return nil, err
 	}
 	return resurls, nil
 }
 
 func (s *UserService) GetURLByShort(ctx context.Context, userID string, shortURL string) (string, error) {
+	ctx, span := tracer.Start(ctx, "UserService.GetURLByShort")
+	defer span.End()
 	if shortURL == "" {
 		return "", errors.New("shortURL is empty")
 	}
@@ -114,13 +232,298 @@ func (s *UserService) GetURLByShort(ctx context.Context, userID string, shortURL
 		return "", urls.ErrNotFound
 	}
 	if err != nil {
+		logger.For(ctx, s.log).Error("can't resolve short url", zap.Error(err))
+		return "", err
+	}
+	// the domain may have been blocklisted after the link was made
+	if err := s.policy.blocked(originalURL); err != nil {
 		return "", err
 	}
-
 	return originalURL, nil
 }
 
+// Resolve returns where shortURL redirects to and how.
+func (s *UserService) Resolve(ctx context.Context, shortURL string) (*urls.Target, error) {
+	ctx, span := tracer.Start(ctx, "UserService.Resolve")
+	defer span.End()
+	target, err := s.dbRepository.FindTarget(ctx, shortURL)
+	if errors.Is(err, &models.NoRowFound) {
+		return nil, urls.ErrNotFound
+	}
+	if err != nil {
+		logger.For(ctx, s.log).Error("can't resolve short url", zap.Error(err))
+		return nil, err
+	}
+	if err := checkWindow(target.ActiveFrom, target.ActiveUntil, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := s.policy.blocked(target.OriginalURL); err != nil {
+		return nil, err
+	}
+	res := &urls.Target{
+		URL:              target.OriginalURL,
+		RedirectType:     target.RedirectType,
+		QueryPassthrough: target.QueryPassthrough,
+		PasswordHash:     target.PasswordHash,
+		Limited:          target.Limited,
+		ActiveUntil:      target.ActiveUntil,
+	}
+	for _, rule := range target.Rules {
+		// a destination blocklisted since the rule was made is skipped
+		if _, blocked := s.policy.blockedBy(rule.URL); blocked {
+			continue
+		}
+		res.Rules = append(res.Rules, urls.Rule{Platform: rule.Platform, Language: rule.Language, URL: rule.URL})
+	}
+	if target.UTM != (models.UTM{}) {
+		res.UTM = &urls.UTM{
+			Source:   target.UTM.Source,
+			Medium:   target.UTM.Medium,
+			Campaign: target.UTM.Campaign,
+			Conflict: target.UTM.Conflict,
+		}
+	}
+	return res, nil
+}
+
+// Unlock checks password against a password-protected link and returns its
+// target. ErrWrongPassword is returned for a mismatch and for a link without
+// a password.
+func (s *UserService) Unlock(ctx context.Context, shortURL string, password string) (*urls.Target, error) {
+	ctx, span := tracer.Start(ctx, "UserService.Unlock")
+	defer span.End()
+	target, err := s.Resolve(ctx, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	if target.PasswordHash == "" || bcrypt.CompareHashAndPassword([]byte(target.PasswordHash), []byte(password)) != nil {
+		return nil, urls.ErrWrongPassword
+	}
+	return target, nil
+}
+
+// UpdateLink replaces the options of a link the user owns, but keeps its
+// password and the clicks it has left if opts has no password or max_clicks.
+// A link of another user is reported as not found.
+func (s *UserService) UpdateLink(ctx context.Context, userID string, shortURL string, opts urls.LinkOptions) error {
+	ctx, span := tracer.Start(ctx, "UserService.UpdateLink")
+	defer span.End()
+	if err := s.checkOptions(opts); err != nil {
+		return err
+	}
+	var err error
+	if opts.Rules, err = s.checkRules(opts.Rules); err != nil {
+		return err
+	}
+	e, err := linkElement("", shortURL, opts)
+	if err != nil {
+		return err
+	}
+	e.KeepPassword = opts.Password == nil
+	e.KeepClicks = opts.MaxClicks == nil
+	err = s.dbRepository.UpdateLink(ctx, userID, e)
+	if errors.Is(err, &models.NoRowFound) {
+		return urls.ErrNotFound
+	}
+	if errors.Is(err, &models.SharedLink) {
+		return &urls.ValidationError{Field: "short_url", Reason: "is shared by everyone who shortened the url; shorten it with options to get a link of your own"}
+	}
+	if err != nil {
+		logger.For(ctx, s.log).Error("can't update link", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *UserService) checkOptions(opts urls.LinkOptions) error {
+	if opts.RedirectType != 0 && !urls.ValidRedirectType(opts.RedirectType) {
+		return &urls.ValidationError{Field: "redirect_type", Reason: fmt.Sprintf("must be one of %v", urls.RedirectTypes)}
+	}
+	if opts.UTM != nil && opts.UTM.Conflict != "" && opts.UTM.Conflict != urls.UTMKeep && opts.UTM.Conflict != urls.UTMOverride {
+		return &urls.ValidationError{Field: "utm.conflict", Reason: fmt.Sprintf("must be %s or %s", urls.UTMKeep, urls.UTMOverride)}
+	}
+	if opts.MaxClicks != nil && *opts.MaxClicks < 0 {
+		return &urls.ValidationError{Field: "max_clicks", Reason: "can't be negative"}
+	}
+	if opts.ActiveFrom != nil && opts.ActiveUntil != nil && !opts.ActiveUntil.After(*opts.ActiveFrom) {
+		return &urls.ValidationError{Field: "active_until", Reason: "must be after active_from"}
+	}
+	// bcrypt ignores everything past 72 bytes
+	if opts.Password != nil && *opts.Password != "" && !s.passwordLinks {
+		return &urls.ValidationError{Field: "password", Reason: "password links are not enabled on this server"}
+	}
+	if opts.Password != nil && len(*opts.Password) > maxPasswordLength {
+		return &urls.ValidationError{Field: "password", Reason: fmt.Sprintf("must be at most %d bytes", maxPasswordLength)}
+	}
+	return nil
+}
+
+const maxPasswordLength = 72
+
+const maxRules = 20
+
+var languageTag = regexp.MustCompile(`^[a-z]{2,8}(-[a-z0-9]{1,8})*$`)
+
+// checkRules validates the targeting rules of a link and returns them with
+// the destinations checked like the link URL and the conditions lowercased.
+func (s *UserService) checkRules(rules []urls.Rule) ([]urls.Rule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if len(rules) > maxRules {
+		return nil, &urls.ValidationError{Field: "rules", Reason: fmt.Sprintf("at most %d rules are allowed", maxRules)}
+	}
+	res := make([]urls.Rule, 0, len(rules))
+	for i, rule := range rules {
+		field := fmt.Sprintf("rules[%d]", i)
+		rule.Platform = strings.ToLower(strings.TrimSpace(rule.Platform))
+		rule.Language = strings.ToLower(strings.TrimSpace(rule.Language))
+		if rule.Platform == "" && rule.Language == "" {
+			return nil, &urls.ValidationError{Field: field, Reason: "needs a platform or a language"}
+		}
+		if rule.Platform != "" && !containsFold(urls.Platforms, rule.Platform) {
+			return nil, &urls.ValidationError{Field: field + ".platform", Reason: fmt.Sprintf("must be one of %v", urls.Platforms)}
+		}
+		if rule.Language != "" && !languageTag.MatchString(rule.Language) {
+			return nil, &urls.ValidationError{Field: field + ".language", Reason: "must be a language tag like en or pt-BR"}
+		}
+		var err error
+		rule.URL, err = s.policy.Check(rule.URL)
+		var validationErr *urls.ValidationError
+		if errors.As(err, &validationErr) {
+			return nil, &urls.ValidationError{Field: field + ".url", Reason: validationErr.Reason}
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rule)
+	}
+	return res, nil
+}
+
+// checkWindow tells whether a link with the activation window from-until
+// redirects at now. Once the window has closed the link is gone like a
+// deleted one.
+func checkWindow(from *time.Time, until *time.Time, now time.Time) error {
+	if until != nil && !now.Before(*until) {
+		return urls.ErrNotFound
+	}
+	if from != nil && now.Before(*from) {
+		return &urls.NotActiveError{ActiveFrom: *from}
+	}
+	return nil
+}
+
+func linkElement(originalURL string, shortURL string, opts urls.LinkOptions) (models.Element, error) {
+	e := models.Element{
+		OriginalURL:      originalURL,
+		ShortURL:         shortURL,
+		Title:            opts.Title,
+		RedirectType:     opts.RedirectType,
+		QueryPassthrough: opts.QueryPassthrough,
+		ActiveFrom:       opts.ActiveFrom,
+		ActiveUntil:      opts.ActiveUntil,
+		OwnCode:          !opts.IsZero(),
+	}
+	if opts.MaxClicks != nil {
+		e.MaxClicks = *opts.MaxClicks
+	}
+	for _, rule := range opts.Rules {
+		e.Rules = append(e.Rules, models.Rule{Platform: rule.Platform, Language: rule.Language, URL: rule.URL})
+	}
+	if opts.UTM != nil {
+		e.UTM = models.UTM{
+			Source:   opts.UTM.Source,
+			Medium:   opts.UTM.Medium,
+			Campaign: opts.UTM.Campaign,
+			Conflict: opts.UTM.Conflict,
+		}
+	}
+	if opts.Password != nil && *opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return e, err
+		}
+		e.PasswordHash = string(hash)
+	}
+	return e, nil
+}
+
+// GetLink returns what the preview page shows about shortURL.
+func (s *UserService) GetLink(ctx context.Context, shortURL string) (*urls.Link, error) {
+	ctx, span := tracer.Start(ctx, "UserService.GetLink")
+	defer span.End()
+	link, err := s.dbRepository.FindLink(ctx, shortURL)
+	if errors.Is(err, &models.NoRowFound) {
+		return nil, urls.ErrNotFound
+	}
+	if err != nil {
+		logger.For(ctx, s.log).Error("can't find link", zap.Error(err))
+		return nil, err
+	}
+	// the preview is shown before the window opens, but not after it closes
+	if err := checkWindow(nil, link.ActiveUntil, time.Now()); err != nil {
+		return nil, err
+	}
+	res := &urls.Link{
+		ShortURL:     s.baseURL + link.ShortURL,
+		OriginalURL:  link.OriginalURL,
+		Title:        link.Title,
+		CreatedAt:    link.CreatedAt,
+		Clicks:       link.Clicks,
+		PasswordHash: link.PasswordHash,
+		ActiveFrom:   link.ActiveFrom,
+		ActiveUntil:  link.ActiveUntil,
+	}
+	res.BlockedBy, _ = s.policy.blockedBy(link.OriginalURL)
+	// showing the destination would get around the click limit
+	if link.ClicksLeft > 0 {
+		res.ClicksLeft = &link.ClicksLeft
+		res.OriginalURL = ""
+	}
+	return res, nil
+}
+
+// RecordClick counts a redirect in memory, so the redirect doesn't wait for
+// the database; see FlushClicks.
+func (s *UserService) RecordClick(ctx context.Context, shortURL string) {
+	s.clicks.add(shortURL, 1)
+}
+
+// TakeClick uses up one click of a click-limited link before it is followed.
+// ErrNotFound means the link has no clicks left, even if another replica
+// took the last one a moment ago.
+func (s *UserService) TakeClick(ctx context.Context, shortURL string) error {
+	ctx, span := tracer.Start(ctx, "UserService.TakeClick")
+	defer span.End()
+	err := s.dbRepository.TakeClick(ctx, shortURL)
+	if errors.Is(err, &models.NoRowFound) {
+		return urls.ErrNotFound
+	}
+	if err != nil {
+		logger.For(ctx, s.log).Error("can't take click", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *UserService) GetStats(ctx context.Context) (*urls.Stats, error) {
+	ctx, span := tracer.Start(ctx, "UserService.GetStats")
+	defer span.End()
+	res, err := s.dbRepository.GetStats(ctx)
+	if err != nil {
+		logger.For(ctx, s.log).Error("can't get stats", zap.Error(err))
+		return nil, err
+	}
+	return &urls.Stats{URLs: res.URLs, Users: res.Users}, nil
+}
+
 func (s *UserService) Ping(ctx context.Context) bool {
-	res, _ := s.dbRepository.Ping(ctx)
+	ctx, span := tracer.Start(ctx, "UserService.Ping")
+	defer span.End()
+	res, err := s.dbRepository.Ping(ctx)
+	if err != nil {
+		logger.For(ctx, s.log).Warn("database ping failed", zap.Error(err))
+	}
 	return res
 }
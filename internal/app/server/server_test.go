@@ -1,9 +1,21 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/da-semenov/go-short-url/internal/app/blocklist"
+	"github.com/da-semenov/go-short-url/internal/app/models"
+	"github.com/da-semenov/go-short-url/internal/app/urls"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestURLService_GetID(t *testing.T) {
@@ -21,9 +33,9 @@ func TestURLService_GetID(t *testing.T) {
 	}{
 		{
 			name: "Test 1. Get ID.",
-			url:  "full_URL",
+			url:  "http://full_URL",
 			want: want{
-				path:   "/full_URL",
+				path:   "/http://full_URL",
 				scheme: "http",
 				host:   "localhost:8080",
 			},
@@ -42,9 +54,9 @@ func TestURLService_GetID(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewUserService(dbRepoMock, fileRepoMock, "http://localhost:8080/")
+			s := NewUserService(dbRepoMock, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
 			s.encode = MockEncode
-			res, _, err := s.GetID(tt.url)
+			res, _, err := s.GetID(tt.url, urls.LinkOptions{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetID() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -61,3 +73,432 @@ func TestURLService_GetID(t *testing.T) {
 		})
 	}
 }
+
+func TestUserService_OwnCode(t *testing.T) {
+	secret := "secret"
+	s := NewUserService(dbRepoMock, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
+
+	_, plain, err := s.GetID("http://example.com/", urls.LinkOptions{})
+	assert.NoError(t, err)
+	_, again, _ := s.GetID("http://example.com/", urls.LinkOptions{})
+	assert.Equal(t, plain, again)
+
+	res, own, err := s.GetID("http://example.com/", urls.LinkOptions{Password: &secret})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/"+own, res)
+	assert.Len(t, own, 11)
+	assert.NotEqual(t, plain, own)
+	_, other, _ := s.GetID("http://example.com/", urls.LinkOptions{Password: &secret})
+	assert.NotEqual(t, own, other)
+	_, routed, _ := s.GetID("http://example.com/", urls.LinkOptions{Rules: []urls.Rule{{Platform: "ios", URL: "https://apps.example/"}}})
+	assert.NotEqual(t, plain, routed)
+}
+
+func TestUserService_Quotas(t *testing.T) {
+	batch := func(n int) []urls.UserBatch {
+		res := make([]urls.UserBatch, n)
+		for i := range res {
+			res[i] = urls.UserBatch{CorrelationID: fmt.Sprint(i), OriginalURL: fmt.Sprint("http://example.com/", i)}
+		}
+		return res
+	}
+	tests := []struct {
+		name      string
+		count     int
+		batchSize int
+		wantErr   *urls.QuotaError
+	}{
+		{name: "Test 1. Within quota.", count: 7, batchSize: 3},
+		{name: "Test 2. Too many links.", count: 8, batchSize: 3, wantErr: &urls.QuotaError{Quota: urls.QuotaLinks, Usage: 8, Limit: 10}},
+		{name: "Test 3. Batch too large.", count: 0, batchSize: 6, wantErr: &urls.QuotaError{Quota: urls.QuotaBatchSize, Usage: 6, Limit: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(DBRepositoryMock)
+			repo.On("CountByUser", "user_id").Return(tt.count, nil)
+			repo.On("SaveBatch", mock.Anything).Return(nil)
+			s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{MaxLinks: 10, MaxBatchSize: 5}, URLPolicy{}, zap.NewNop())
+
+			_, err := s.SaveBatch(context.Background(), "user_id", batch(tt.batchSize))
+
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				repo.AssertCalled(t, "SaveBatch", mock.Anything)
+				return
+			}
+			var quotaErr *urls.QuotaError
+			assert.True(t, errors.As(err, &quotaErr))
+			assert.Equal(t, tt.wantErr, quotaErr)
+			repo.AssertNotCalled(t, "SaveBatch", mock.Anything)
+		})
+	}
+}
+
+func TestUserService_SaveUserURLQuota(t *testing.T) {
+	repo := new(DBRepositoryMock)
+	repo.On("CountByUser", "user_id").Return(10, nil)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{MaxLinks: 10}, URLPolicy{}, zap.NewNop())
+
+	err := s.SaveUserURL(context.Background(), "user_id", "http://example.com", "short_URL", urls.LinkOptions{})
+
+	assert.Equal(t, &urls.QuotaError{Quota: urls.QuotaLinks, Usage: 10, Limit: 10}, err)
+	repo.AssertNotCalled(t, "Save", mock.Anything, mock.Anything)
+}
+
+func TestUserService_Blocklist(t *testing.T) {
+	rules, err := blocklist.Parse(strings.NewReader("evil.example\n"))
+	assert.NoError(t, err)
+	repo := new(DBRepositoryMock)
+	repo.On("FindByShort", "", "blocked").Return("https://login.evil.example/", nil)
+	repo.On("FindByShort", "", "clean").Return("https://example.com/", nil)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{
+		Normalize: true,
+		Blocklist: blocklist.NewBlocklist(rules),
+	}, zap.NewNop())
+
+	_, _, err = s.GetID("HTTPS://EVIL.example/x", urls.LinkOptions{})
+	assert.Equal(t, &urls.BlockedError{URL: "https://evil.example/x", Rule: "evil.example"}, err)
+
+	_, err = s.GetURLByShort(context.Background(), "", "blocked")
+	assert.Equal(t, &urls.BlockedError{URL: "https://login.evil.example/", Rule: "evil.example"}, err)
+
+	res, err := s.GetURLByShort(context.Background(), "", "clean")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/", res)
+}
+
+func TestUserService_GetLink(t *testing.T) {
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	rules, err := blocklist.Parse(strings.NewReader("evil.example\n"))
+	assert.NoError(t, err)
+	repo := new(DBRepositoryMock)
+	repo.On("FindLink", "short_URL").Return(&models.Link{ShortURL: "short_URL", OriginalURL: "http://evil.example/", Title: "Title", CreatedAt: created, Clicks: 3}, nil)
+	repo.On("FindLink", "limited_URL").Return(&models.Link{ShortURL: "limited_URL", OriginalURL: "http://example.com/file", CreatedAt: created, Clicks: 1, ClicksLeft: 2}, nil)
+	repo.On("FindLink", "badURL").Return(nil, &models.NoRowFound)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{Blocklist: blocklist.NewBlocklist(rules)}, zap.NewNop())
+
+	res, err := s.GetLink(context.Background(), "short_URL")
+	assert.NoError(t, err)
+	assert.Equal(t, &urls.Link{
+		ShortURL:    "http://localhost:8080/short_URL",
+		OriginalURL: "http://evil.example/",
+		Title:       "Title",
+		CreatedAt:   created,
+		Clicks:      3,
+		BlockedBy:   "evil.example",
+	}, res)
+
+	res, err = s.GetLink(context.Background(), "limited_URL")
+	assert.NoError(t, err)
+	left := int64(2)
+	assert.Equal(t, &urls.Link{ShortURL: "http://localhost:8080/limited_URL", CreatedAt: created, Clicks: 1, ClicksLeft: &left}, res)
+
+	_, err = s.GetLink(context.Background(), "badURL")
+	assert.Equal(t, urls.ErrNotFound, err)
+}
+
+func TestUserService_SaveTitle(t *testing.T) {
+	repo := new(DBRepositoryMock)
+	repo.On("Save", "user_id", models.Element{OriginalURL: "http://example.com", ShortURL: "short_URL", Title: "Title", OwnCode: true}).Return(nil)
+	repo.On("SaveBatch", mock.MatchedBy(func(data models.UserBatchURLs) bool {
+		return len(data.List) == 1 && data.List[0].Title == "Batch title"
+	})).Return(nil)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
+
+	err := s.SaveUserURL(context.Background(), "user_id", "http://example.com", "short_URL", urls.LinkOptions{Title: "Title"})
+	assert.NoError(t, err)
+	_, err = s.SaveBatch(context.Background(), "user_id", []urls.UserBatch{
+		{CorrelationID: "c1", OriginalURL: "http://example.com/1", LinkOptions: urls.LinkOptions{Title: "Batch title"}},
+	})
+	assert.NoError(t, err)
+}
+
+func TestUserService_Resolve(t *testing.T) {
+	repo := new(DBRepositoryMock)
+	repo.On("FindTarget", "short_URL").Return(&models.Target{OriginalURL: "http://example.com/", RedirectType: 301}, nil)
+	repo.On("FindTarget", "utm_URL").Return(&models.Target{OriginalURL: "http://example.com/", QueryPassthrough: true, UTM: models.UTM{Source: "news"}}, nil)
+	repo.On("FindTarget", "badURL").Return(nil, &models.NoRowFound)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
+
+	res, err := s.Resolve(context.Background(), "short_URL")
+	assert.NoError(t, err)
+	assert.Equal(t, &urls.Target{URL: "http://example.com/", RedirectType: 301}, res)
+
+	res, err = s.Resolve(context.Background(), "utm_URL")
+	assert.NoError(t, err)
+	assert.Equal(t, &urls.Target{URL: "http://example.com/", QueryPassthrough: true, UTM: &urls.UTM{Source: "news"}}, res)
+
+	_, err = s.Resolve(context.Background(), "badURL")
+	assert.Equal(t, urls.ErrNotFound, err)
+}
+
+func TestUserService_Rules(t *testing.T) {
+	rules, err := blocklist.Parse(strings.NewReader("evil.example\n"))
+	assert.NoError(t, err)
+	repo := new(DBRepositoryMock)
+	repo.On("Save", "user_id", models.Element{OriginalURL: "http://example.com/", ShortURL: "short_URL", Rules: []models.Rule{
+		{Platform: "ios", URL: "https://apps.apple.com/app/id1"},
+		{Language: "pt-br", URL: "http://example.com/br/"},
+	}, OwnCode: true}).Return(nil)
+	repo.On("FindTarget", "short_URL").Return(&models.Target{OriginalURL: "http://example.com/", Rules: []models.Rule{
+		{Platform: "android", URL: "http://evil.example/app"},
+		{Language: "de", URL: "http://example.com/de/"},
+	}}, nil)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{Blocklist: blocklist.NewBlocklist(rules)}, zap.NewNop())
+
+	err = s.SaveUserURL(context.Background(), "user_id", "http://example.com/", "short_URL", urls.LinkOptions{Rules: []urls.Rule{
+		{Platform: " iOS", URL: "https://apps.apple.com/app/id1"},
+		{Language: "pt-BR", URL: "http://example.com/br/"},
+	}})
+	assert.NoError(t, err)
+
+	_, err = s.checkRules([]urls.Rule{{Platform: "android", URL: "http://evil.example/app"}})
+	assert.Equal(t, &urls.BlockedError{URL: "http://evil.example/app", Rule: "evil.example"}, err)
+
+	// a rule blocklisted after it was made is skipped
+	res, err := s.Resolve(context.Background(), "short_URL")
+	assert.NoError(t, err)
+	assert.Equal(t, &urls.Target{URL: "http://example.com/", Rules: []urls.Rule{{Language: "de", URL: "http://example.com/de/"}}}, res)
+}
+
+func TestUserService_UpdateLink(t *testing.T) {
+	from := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	until := from.Add(24 * time.Hour)
+	secret, noPassword, longPassword := "secret", "", strings.Repeat("p", 73)
+	noLimit, negative := 0, -1
+	repo := new(DBRepositoryMock)
+	repo.On("UpdateLink", "user_id", models.Element{ShortURL: "short_URL", Title: "Title", RedirectType: 302, OwnCode: true, KeepPassword: true, KeepClicks: true}).Return(nil)
+	repo.On("UpdateLink", "user_id", models.Element{ShortURL: "short_URL", KeepClicks: true}).Return(nil)
+	repo.On("UpdateLink", "user_id", models.Element{ShortURL: "short_URL", KeepPassword: true}).Return(nil)
+	repo.On("UpdateLink", "other_user", mock.Anything).Return(&models.NoRowFound)
+	repo.On("UpdateLink", "plain_user", mock.Anything).Return(&models.SharedLink)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
+	s.EnablePasswordLinks()
+
+	tests := []struct {
+		name    string
+		userID  string
+		opts    urls.LinkOptions
+		wantErr error
+	}{
+		{name: "Test 1. Update.", userID: "user_id", opts: urls.LinkOptions{Title: "Title", RedirectType: 302}},
+		{name: "Test 2. Other user.", userID: "other_user", opts: urls.LinkOptions{}, wantErr: urls.ErrNotFound},
+		{name: "Test 3. Invalid redirect type.", userID: "user_id", opts: urls.LinkOptions{RedirectType: 200},
+			wantErr: &urls.ValidationError{Field: "redirect_type", Reason: "must be one of [301 302 307 308]"}},
+		{name: "Test 4. Invalid UTM conflict rule.", userID: "user_id", opts: urls.LinkOptions{UTM: &urls.UTM{Source: "news", Conflict: "merge"}},
+			wantErr: &urls.ValidationError{Field: "utm.conflict", Reason: "must be keep or override"}},
+		{name: "Test 5. Password too long.", userID: "user_id", opts: urls.LinkOptions{Password: &longPassword},
+			wantErr: &urls.ValidationError{Field: "password", Reason: "must be at most 72 bytes"}},
+		{name: "Test 6. Negative max clicks.", userID: "user_id", opts: urls.LinkOptions{MaxClicks: &negative},
+			wantErr: &urls.ValidationError{Field: "max_clicks", Reason: "can't be negative"}},
+		{name: "Test 7. Empty activation window.", userID: "user_id", opts: urls.LinkOptions{ActiveFrom: &until, ActiveUntil: &from},
+			wantErr: &urls.ValidationError{Field: "active_until", Reason: "must be after active_from"}},
+		{name: "Test 8. Rule without a condition.", userID: "user_id", opts: urls.LinkOptions{Rules: []urls.Rule{{URL: "http://example.com/"}}},
+			wantErr: &urls.ValidationError{Field: "rules[0]", Reason: "needs a platform or a language"}},
+		{name: "Test 9. Unknown platform.", userID: "user_id", opts: urls.LinkOptions{Rules: []urls.Rule{{Platform: "ios", URL: "http://example.com/"}, {Platform: "symbian", URL: "http://example.com/"}}},
+			wantErr: &urls.ValidationError{Field: "rules[1].platform", Reason: "must be one of [ios android windows macos linux]"}},
+		{name: "Test 10. Invalid language.", userID: "user_id", opts: urls.LinkOptions{Rules: []urls.Rule{{Language: "en_US", URL: "http://example.com/"}}},
+			wantErr: &urls.ValidationError{Field: "rules[0].language", Reason: "must be a language tag like en or pt-BR"}},
+		{name: "Test 11. Invalid rule URL.", userID: "user_id", opts: urls.LinkOptions{Rules: []urls.Rule{{Language: "de", URL: "javascript:alert(1)"}}},
+			wantErr: &urls.ValidationError{Field: "rules[0].url", Reason: `scheme "javascript" is not allowed`}},
+		{name: "Test 12. Shared code.", userID: "plain_user", opts: urls.LinkOptions{Password: &secret},
+			wantErr: &urls.ValidationError{Field: "short_url", Reason: "is shared by everyone who shortened the url; shorten it with options to get a link of your own"}},
+		{name: "Test 13. Password removed.", userID: "user_id", opts: urls.LinkOptions{Password: &noPassword}},
+		{name: "Test 14. Click limit removed.", userID: "user_id", opts: urls.LinkOptions{MaxClicks: &noLimit}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.UpdateLink(context.Background(), tt.userID, "short_URL", tt.opts)
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestUserService_ActiveWindow(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	repo := new(DBRepositoryMock)
+	repo.On("FindTarget", "open_URL").Return(&models.Target{OriginalURL: "http://example.com/", ActiveFrom: &past, ActiveUntil: &future}, nil)
+	repo.On("FindTarget", "early_URL").Return(&models.Target{OriginalURL: "http://example.com/", ActiveFrom: &future}, nil)
+	repo.On("FindTarget", "late_URL").Return(&models.Target{OriginalURL: "http://example.com/", ActiveUntil: &past}, nil)
+	repo.On("FindLink", "early_URL").Return(&models.Link{ShortURL: "early_URL", OriginalURL: "http://example.com/", ActiveFrom: &future}, nil)
+	repo.On("FindLink", "late_URL").Return(&models.Link{ShortURL: "late_URL", OriginalURL: "http://example.com/", ActiveUntil: &past}, nil)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
+
+	tests := []struct {
+		name     string
+		shortURL string
+		wantErr  error
+	}{
+		{name: "Test 1. Inside the window.", shortURL: "open_URL"},
+		{name: "Test 2. Before the window.", shortURL: "early_URL", wantErr: &urls.NotActiveError{ActiveFrom: future}},
+		{name: "Test 3. After the window.", shortURL: "late_URL", wantErr: urls.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Resolve(context.Background(), tt.shortURL)
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+	target, err := s.Resolve(context.Background(), "open_URL")
+	assert.NoError(t, err)
+	assert.Equal(t, &future, target.ActiveUntil)
+
+	// the preview is there before the window opens
+	link, err := s.GetLink(context.Background(), "early_URL")
+	assert.NoError(t, err)
+	assert.Equal(t, &future, link.ActiveFrom)
+	_, err = s.GetLink(context.Background(), "late_URL")
+	assert.Equal(t, urls.ErrNotFound, err)
+}
+
+func TestUserService_TakeClick(t *testing.T) {
+	repo := new(DBRepositoryMock)
+	repo.On("TakeClick", "short_URL").Return(nil)
+	repo.On("TakeClick", "used_URL").Return(&models.NoRowFound)
+	repo.On("TakeClick", "broken_URL").Return(errors.New("connection refused"))
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
+
+	assert.NoError(t, s.TakeClick(context.Background(), "short_URL"))
+	assert.Equal(t, urls.ErrNotFound, s.TakeClick(context.Background(), "used_URL"))
+	assert.EqualError(t, s.TakeClick(context.Background(), "broken_URL"), "connection refused")
+}
+
+func TestUserService_Password(t *testing.T) {
+	var hash string
+	secret := "secret"
+	repo := new(DBRepositoryMock)
+	repo.On("Save", "user_id", mock.MatchedBy(func(e models.Element) bool {
+		hash = e.PasswordHash
+		return bcrypt.CompareHashAndPassword([]byte(e.PasswordHash), []byte("secret")) == nil
+	})).Return(nil)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
+
+	err := s.SaveUserURL(context.Background(), "user_id", "http://example.com", "short_URL", urls.LinkOptions{Password: &secret})
+	assert.Equal(t, &urls.ValidationError{Field: "password", Reason: "password links are not enabled on this server"}, err)
+	s.EnablePasswordLinks()
+	err = s.SaveUserURL(context.Background(), "user_id", "http://example.com", "short_URL", urls.LinkOptions{Password: &secret})
+	assert.NoError(t, err)
+	repo.On("FindTarget", "short_URL").Return(&models.Target{OriginalURL: "http://example.com", PasswordHash: hash}, nil)
+	repo.On("FindTarget", "open_URL").Return(&models.Target{OriginalURL: "http://example.com"}, nil)
+
+	tests := []struct {
+		name     string
+		shortURL string
+		password string
+		wantErr  error
+	}{
+		{name: "Test 1. Right password.", shortURL: "short_URL", password: "secret"},
+		{name: "Test 2. Wrong password.", shortURL: "short_URL", password: "Secret", wantErr: urls.ErrWrongPassword},
+		{name: "Test 3. Link without a password.", shortURL: "open_URL", password: "", wantErr: urls.ErrWrongPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := s.Unlock(context.Background(), tt.shortURL, tt.password)
+			assert.Equal(t, tt.wantErr, err)
+			if tt.wantErr == nil {
+				assert.Equal(t, &urls.Target{URL: "http://example.com", PasswordHash: hash}, res)
+			}
+		})
+	}
+}
+
+func TestDeleteService_DeleteBatchQuota(t *testing.T) {
+	s := NewDeleteService(nil, 1, 10, 2, zap.NewNop())
+	err := s.DeleteBatch(context.Background(), "user_id", []string{"a", "b", "c"})
+	assert.Equal(t, &urls.QuotaError{Quota: urls.QuotaDeleteSize, Usage: 3, Limit: 2}, err)
+	assert.Equal(t, 0, s.QueueDepth())
+}
+
+type slowDeleteRepository struct {
+	deleted int64
+}
+
+func (r *slowDeleteRepository) BatchDelete(ctx context.Context, userID string, URLList []string) error {
+	time.Sleep(10 * time.Millisecond)
+	atomic.AddInt64(&r.deleted, int64(len(URLList)))
+	return nil
+}
+
+func TestDeleteService_Close(t *testing.T) {
+	repo := new(slowDeleteRepository)
+	s := NewDeleteService(repo, 2, 10, 0, zap.NewNop())
+	for _, code := range []string{"a", "b", "c", "d", "e"} {
+		assert.NoError(t, s.DeleteBatch(context.Background(), "user_id", []string{code}))
+	}
+
+	assert.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, int64(5), atomic.LoadInt64(&repo.deleted), "queued deletes are done before Close returns")
+	assert.Equal(t, errDeleteClosed, s.DeleteBatch(context.Background(), "user_id", []string{"f"}))
+	assert.NoError(t, s.Close(context.Background()))
+}
+
+func TestURLPolicy_Check(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     URLPolicy
+		url        string
+		want       string
+		wantReason string
+	}{
+		{name: "Test 1. Valid URL is kept.", url: " https://Example.com/a?b=2&a=1#top\n", want: "https://Example.com/a?b=2&a=1#top"},
+		{name: "Test 2. Empty.", url: "  ", wantReason: "url is empty"},
+		{name: "Test 3. Javascript.", url: "javascript:alert(1)", wantReason: `scheme "javascript" is not allowed`},
+		{name: "Test 4. No host.", url: "http:///path", wantReason: "url has no host"},
+		{name: "Test 5. Whitespace inside.", url: "http://example.com/a b", wantReason: "url contains whitespace or control characters"},
+		{name: "Test 6. Too long.", policy: URLPolicy{MaxLength: 20}, url: "http://example.com/long/path", wantReason: "url is longer than 20 characters"},
+		{name: "Test 7. Not an allowed scheme.", policy: URLPolicy{AllowedSchemes: []string{"https"}}, url: "http://example.com", wantReason: `scheme "http" is not allowed`},
+		{name: "Test 8. Normalized.", policy: URLPolicy{Normalize: true}, url: "HTTP://Example.COM:80?b=2&a=1#top", want: "http://example.com/?a=1&b=2"},
+		{name: "Test 9. Normalized keeps other ports.", policy: URLPolicy{Normalize: true}, url: "https://example.com:8443/x", want: "https://example.com:8443/x"},
+		{name: "Test 10. Normalized IPv6.", policy: URLPolicy{Normalize: true}, url: "http://[::1]:80/", want: "http://[::1]/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Check(tt.url)
+			if tt.wantReason != "" {
+				var validationErr *urls.ValidationError
+				assert.True(t, errors.As(err, &validationErr))
+				assert.Equal(t, tt.wantReason, validationErr.Reason)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestUserService_FlushClicks(t *testing.T) {
+	repo := new(DBRepositoryMock)
+	repo.On("AddClicks", map[string]int64{"a": 2, "b": 1}).Return(errors.New("connection refused")).Once()
+	repo.On("AddClicks", map[string]int64{"a": 3, "b": 1}).Return(nil).Once()
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
+
+	assert.NoError(t, s.FlushClicks(context.Background()), "nothing to flush")
+	s.RecordClick(context.Background(), "a")
+	s.RecordClick(context.Background(), "a")
+	s.RecordClick(context.Background(), "b")
+	assert.EqualError(t, s.FlushClicks(context.Background()), "connection refused")
+
+	// the failed flush is retried with the clicks since then
+	s.RecordClick(context.Background(), "a")
+	assert.NoError(t, s.FlushClicks(context.Background()))
+	assert.NoError(t, s.FlushClicks(context.Background()))
+	repo.AssertNumberOfCalls(t, "AddClicks", 2)
+}
+
+func TestUserService_RunClickFlusher(t *testing.T) {
+	repo := new(DBRepositoryMock)
+	repo.On("AddClicks", map[string]int64{"a": 1}).Return(nil)
+	s := NewUserService(repo, fileRepoMock, "http://localhost:8080/", Quotas{}, URLPolicy{}, zap.NewNop())
+	s.RecordClick(context.Background(), "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.RunClickFlusher(ctx, time.Hour)
+		close(done)
+	}()
+	cancel()
+	<-done
+	repo.AssertCalled(t, "AddClicks", map[string]int64{"a": 1})
+}
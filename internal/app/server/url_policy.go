@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"github.com/da-semenov/go-short-url/internal/app/urls"
+	"net"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+var defaultSchemes = []string{"http", "https"}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// Blocklist finds the rule a URL is blocked by.
+type Blocklist interface {
+	Match(url string) (rule string, blocked bool)
+}
+
+// URLPolicy decides which URLs may be shortened. The zero value allows http
+// and https URLs of any length and keeps them as they are.
+type URLPolicy struct {
+	AllowedSchemes []string
+	MaxLength      int
+	// Normalize rewrites URLs to a canonical form, so trivially different
+	// spellings of one URL get the same short code.
+	Normalize bool
+	// Blocklist screens the URL after validation; nil blocks nothing.
+	Blocklist Blocklist
+}
+
+// Check returns the URL to store, or a *urls.ValidationError with the reason
+// it was rejected, or a *urls.BlockedError if it is blocklisted.
+func (p URLPolicy) Check(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", &urls.ValidationError{Reason: "url is empty"}
+	}
+	if p.MaxLength > 0 && len(raw) > p.MaxLength {
+		return "", &urls.ValidationError{Reason: fmt.Sprintf("url is longer than %d characters", p.MaxLength)}
+	}
+	if strings.IndexFunc(raw, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "", &urls.ValidationError{Reason: "url contains whitespace or control characters"}
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", &urls.ValidationError{Reason: "url can't be parsed"}
+	}
+	schemes := p.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = defaultSchemes
+	}
+	if !containsFold(schemes, u.Scheme) {
+		return "", &urls.ValidationError{Reason: fmt.Sprintf("scheme %q is not allowed", u.Scheme)}
+	}
+	if u.Hostname() == "" {
+		return "", &urls.ValidationError{Reason: "url has no host"}
+	}
+	if p.Normalize {
+		raw = normalize(u)
+	}
+	if err := p.blocked(raw); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+func (p URLPolicy) blocked(url string) error {
+	if rule, blocked := p.blockedBy(url); blocked {
+		return &urls.BlockedError{URL: url, Rule: rule}
+	}
+	return nil
+}
+
+func (p URLPolicy) blockedBy(url string) (string, bool) {
+	if p.Blocklist == nil {
+		return "", false
+	}
+	return p.Blocklist.Match(url)
+}
+
+// normalize lowercases scheme and host, drops the default port and the
+// fragment and sorts the query parameters.
+func normalize(u *url.URL) string {
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode()
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
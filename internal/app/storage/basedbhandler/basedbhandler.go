@@ -2,21 +2,56 @@ package basedbhandler
 
 import (
 	"context"
+	"fmt"
 )
 
 type DBHandler interface {
 	Execute(ctx context.Context, statement string, args ...interface{}) error
-	ExecuteBatch(ctx context.Context, statement string, args [][]interface{}) error
+	ExecuteBatch(ctx context.Context, statement string, args [][]interface{}) (BatchResult, error)
 	Query(ctx context.Context, statement string, args ...interface{}) (Rows, error)
 	QueryRow(ctx context.Context, statement string, args ...interface{}) (Row, error)
+	// WithTx runs fn inside a single transaction. The transaction is committed
+	// when fn returns nil and rolled back otherwise.
+	WithTx(ctx context.Context, fn func(tx DBHandler) error) error
 	Close()
 }
 
+// Rows holds its connection until Close is called or Next returns false.
 type Rows interface {
 	Scan(dest ...interface{}) error
 	Next() bool
+	Err() error
+	Close()
 }
 
 type Row interface {
 	Scan(dest ...interface{}) error
 }
+
+// BatchResult is the rows-affected summary of ExecuteBatch, one entry per statement.
+type BatchResult struct {
+	RowsAffected []int64
+}
+
+func (r BatchResult) Total() int64 {
+	var total int64
+	for _, n := range r.RowsAffected {
+		total += n
+	}
+	return total
+}
+
+// BatchError reports the statement of a batch that failed.
+type BatchError struct {
+	Index int
+	Args  []interface{}
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch statement %d failed: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
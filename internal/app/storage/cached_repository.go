@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/da-semenov/go-short-url/internal/app/models"
+)
+
+// CachedRepository is a read-through cache over models.DBRepository for
+// resolving short codes. Only lookups that are not scoped to a user are cached.
+type CachedRepository struct {
+	models.DBRepository
+	cache *URLCache
+}
+
+func NewCachedRepository(repo models.DBRepository, cache *URLCache) (*CachedRepository, error) {
+	var r CachedRepository
+	r.DBRepository = repo
+	r.cache = cache
+	return &r, nil
+}
+
+func (r *CachedRepository) FindByShort(ctx context.Context, userID string, shortURL string) (string, error) {
+	if userID != "" {
+		return r.DBRepository.FindByShort(ctx, userID, shortURL)
+	}
+	res, err := r.FindTarget(ctx, shortURL)
+	if err != nil {
+		return "", err
+	}
+	return res.OriginalURL, nil
+}
+
+func (r *CachedRepository) FindTarget(ctx context.Context, shortURL string) (*models.Target, error) {
+	if value, found, ok := r.cache.Get(shortURL); ok {
+		if !found {
+			return nil, &models.NoRowFound
+		}
+		return &value, nil
+	}
+	gen := r.cache.Generation()
+	res, err := r.DBRepository.FindTarget(ctx, shortURL)
+	if errors.Is(err, &models.NoRowFound) {
+		r.cache.SetMissing(gen, shortURL)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.cache.Set(gen, shortURL, *res)
+	return res, nil
+}
+
+func (r *CachedRepository) Save(ctx context.Context, userID string, e models.Element) error {
+	defer r.cache.Invalidate(e.ShortURL)
+	return r.DBRepository.Save(ctx, userID, e)
+}
+
+func (r *CachedRepository) UpdateLink(ctx context.Context, userID string, e models.Element) error {
+	defer r.cache.Invalidate(e.ShortURL)
+	return r.DBRepository.UpdateLink(ctx, userID, e)
+}
+
+// TakeClick drops the cached target of a link that has no clicks left.
+func (r *CachedRepository) TakeClick(ctx context.Context, shortURL string) error {
+	err := r.DBRepository.TakeClick(ctx, shortURL)
+	if errors.Is(err, &models.NoRowFound) {
+		r.cache.Invalidate(shortURL)
+	}
+	return err
+}
+
+func (r *CachedRepository) SaveBatch(ctx context.Context, data models.UserBatchURLs) error {
+	defer r.invalidateBatch(data)
+	return r.DBRepository.SaveBatch(ctx, data)
+}
+
+func (r *CachedRepository) invalidateBatch(data models.UserBatchURLs) {
+	keys := make([]string, 0, len(data.List))
+	for _, e := range data.List {
+		keys = append(keys, e.ShortURL)
+	}
+	r.cache.Invalidate(keys...)
+}
+
+// CachedDeleteRepository evicts deleted codes from the cache.
+type CachedDeleteRepository struct {
+	models.DeleteRepository
+	cache *URLCache
+}
+
+func NewCachedDeleteRepository(repo models.DeleteRepository, cache *URLCache) (*CachedDeleteRepository, error) {
+	var r CachedDeleteRepository
+	r.DeleteRepository = repo
+	r.cache = cache
+	return &r, nil
+}
+
+func (r *CachedDeleteRepository) BatchDelete(ctx context.Context, userID string, URLList []string) error {
+	defer r.cache.Invalidate(URLList...)
+	return r.DeleteRepository.BatchDelete(ctx, userID, URLList)
+}
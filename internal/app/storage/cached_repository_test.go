@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"github.com/da-semenov/go-short-url/internal/app/database"
+	"github.com/da-semenov/go-short-url/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCachedRepository_FindByShort(t *testing.T) {
+	h := new(DBHandlerMock)
+	h.On("QueryRow", database.GetTargetByShort, []interface{}{"short_URL"}).Return(&RowMock{Values: []interface{}{"original_URL", 301, true, "news", "", "", "", "", false, nil, nil, `[{"language":"de","url":"https://example.de/"}]`}}, nil)
+	h.On("QueryRow", database.GetTargetByShort, []interface{}{"badURL"}).Return(&RowMock{Err: &models.NoRowFound}, nil)
+	h.On("ExecuteBatch", database.DeleteUserURL, [][]interface{}{{"user_id", "short_URL"}}).Return(nil, nil)
+	h.On("Execute", database.NotifyInvalidation, []interface{}{"short_URL"}).Return(nil)
+	pgRepo, _ := NewPostgresRepository(h)
+	deleteRepo, _ := NewDeleteRepository(h)
+	cache := NewURLCache(10, time.Minute, time.Minute)
+	repo, _ := NewCachedRepository(pgRepo, cache)
+	cachedDeleteRepo, _ := NewCachedDeleteRepository(deleteRepo, cache)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		res, err := repo.FindByShort(ctx, "", "short_URL")
+		assert.NoError(t, err)
+		assert.Equal(t, "original_URL", res)
+		_, err = repo.FindByShort(ctx, "", "badURL")
+		assert.ErrorIs(t, err, &models.NoRowFound)
+	}
+	h.AssertNumberOfCalls(t, "QueryRow", 2)
+	target, err := repo.FindTarget(ctx, "short_URL")
+	assert.NoError(t, err)
+	assert.Equal(t, &models.Target{OriginalURL: "original_URL", RedirectType: 301, QueryPassthrough: true, UTM: models.UTM{Source: "news"},
+		Rules: []models.Rule{{Language: "de", URL: "https://example.de/"}}}, target)
+	h.AssertNumberOfCalls(t, "QueryRow", 2)
+
+	err = cachedDeleteRepo.BatchDelete(ctx, "user_id", []string{"short_URL"})
+	assert.NoError(t, err)
+	_, err = repo.FindByShort(ctx, "", "short_URL")
+	assert.NoError(t, err)
+	h.AssertNumberOfCalls(t, "QueryRow", 3)
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"github.com/da-semenov/go-short-url/internal/app/storage/basedbhandler"
+	"github.com/stretchr/testify/mock"
+	"time"
+)
+
+type DBHandlerMock struct {
+	mock.Mock
+	Committed  int
+	RolledBack int
+}
+
+func (h *DBHandlerMock) Execute(ctx context.Context, statement string, args ...interface{}) error {
+	a := h.Called(statement, args)
+	return a.Error(0)
+}
+
+func (h *DBHandlerMock) ExecuteBatch(ctx context.Context, statement string, args [][]interface{}) (basedbhandler.BatchResult, error) {
+	a := h.Called(statement, args)
+	res, _ := a.Get(0).(basedbhandler.BatchResult)
+	return res, a.Error(1)
+}
+
+func (h *DBHandlerMock) Query(ctx context.Context, statement string, args ...interface{}) (basedbhandler.Rows, error) {
+	a := h.Called(statement, args)
+	rows, _ := a.Get(0).(basedbhandler.Rows)
+	return rows, a.Error(1)
+}
+
+func (h *DBHandlerMock) QueryRow(ctx context.Context, statement string, args ...interface{}) (basedbhandler.Row, error) {
+	a := h.Called(statement, args)
+	row, _ := a.Get(0).(basedbhandler.Row)
+	return row, a.Error(1)
+}
+
+// WithTx runs fn against the mock itself and counts commits and rollbacks,
+// so tests can check that a multi-statement write is atomic.
+func (h *DBHandlerMock) WithTx(ctx context.Context, fn func(tx basedbhandler.DBHandler) error) error {
+	err := fn(h)
+	if err != nil {
+		h.RolledBack++
+		return err
+	}
+	h.Committed++
+	return nil
+}
+
+func (h *DBHandlerMock) Close() {
+}
+
+type RowMock struct {
+	Values []interface{}
+	Err    error
+}
+
+func (r *RowMock) Scan(dest ...interface{}) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	for i, v := range r.Values {
+		switch d := dest[i].(type) {
+		case *int64:
+			*d = v.(int64)
+		case *int:
+			*d = v.(int)
+		case *string:
+			*d = v.(string)
+		case *bool:
+			*d = v.(bool)
+		case *[]string:
+			*d, _ = v.([]string)
+		case *time.Time:
+			*d = v.(time.Time)
+		case **time.Time:
+			*d, _ = v.(*time.Time)
+		}
+	}
+	return nil
+}
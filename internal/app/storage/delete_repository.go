@@ -18,14 +18,21 @@ func NewDeleteRepository(handler basedbhandler.DBHandler) (*DeleteRepository, er
 
 func (r *DeleteRepository) BatchDelete(ctx context.Context, userID string, URLList []string) error {
 	var paramArr [][]interface{}
+	var codes []string
 	for _, l := range URLList {
 		var paramLine []interface{}
 		if l != "" {
 			paramLine = append(paramLine, userID)
 			paramLine = append(paramLine, l)
 			paramArr = append(paramArr, paramLine)
+			codes = append(codes, l)
 		}
 	}
-	err := r.handler.ExecuteBatch(ctx, database.DeleteUserURL, paramArr)
-	return err
+	return r.handler.WithTx(ctx, func(tx basedbhandler.DBHandler) error {
+		_, err := tx.ExecuteBatch(ctx, database.DeleteUserURL, paramArr)
+		if err != nil {
+			return err
+		}
+		return notifyInvalidation(ctx, tx, codes)
+	})
 }
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"encoding/gob"
 	"errors"
 	"github.com/da-semenov/go-short-url/internal/app/models"
@@ -142,3 +143,20 @@ func (s *FileStorage) Save(key string, value string) error {
 	}
 	return err
 }
+
+func (s *FileStorage) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.store)
+}
+
+// CheckWritable makes sure new records can still be written to the storage directory.
+func (s *FileStorage) CheckWritable(ctx context.Context) error {
+	f, err := os.CreateTemp(path.Dir(s.cfgFileStorage), ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
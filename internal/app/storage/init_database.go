@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/da-semenov/go-short-url/internal/app/database"
 	"github.com/da-semenov/go-short-url/internal/app/storage/basedbhandler"
+	"strings"
 )
 
 func InitDatabase(ctx context.Context, h basedbhandler.DBHandler) error {
@@ -12,7 +13,6 @@ func InitDatabase(ctx context.Context, h basedbhandler.DBHandler) error {
 	if err != nil {
 		return err
 	}
-	fmt.Println("database structure created successfully")
 	return nil
 }
 
@@ -23,3 +23,42 @@ func ClearDatabase(ctx context.Context, h basedbhandler.DBHandler) error {
 	}
 	return nil
 }
+
+// CheckDatabaseStructure reports whether InitDatabase has created all tables
+// and brought them up to date: every added column and index is there.
+func CheckDatabaseStructure(ctx context.Context, h basedbhandler.DBHandler) error {
+	row, err := h.QueryRow(ctx, database.CountDatabaseTables)
+	if err != nil {
+		return err
+	}
+	var count int
+	err = row.Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != database.DatabaseTablesCount {
+		return fmt.Errorf("database structure is incomplete: %d of %d tables", count, database.DatabaseTablesCount)
+	}
+	for _, check := range []struct {
+		what      string
+		statement string
+		names     []string
+	}{
+		{"urls columns", database.MissingURLColumns, database.URLColumns},
+		{"indexes", database.MissingIndexes, database.DatabaseIndexes},
+	} {
+		row, err = h.QueryRow(ctx, check.statement, check.names)
+		if err != nil {
+			return err
+		}
+		var missing []string
+		err = row.Scan(&missing)
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("database structure is not migrated: missing %s %s", check.what, strings.Join(missing, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"github.com/da-semenov/go-short-url/internal/app/database"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCheckDatabaseStructure(t *testing.T) {
+	tests := []struct {
+		name           string
+		tables         int
+		missingColumns []string
+		missingIndexes []string
+		wantErr        string
+	}{
+		{name: "Test 1. Migrated.", tables: 2},
+		{name: "Test 2. Missing table.", tables: 1, wantErr: "database structure is incomplete: 1 of 2 tables"},
+		{name: "Test 3. Missing columns.", tables: 2, missingColumns: []string{"rules", "own_code"},
+			wantErr: "database structure is not migrated: missing urls columns rules, own_code"},
+		{name: "Test 4. Missing index.", tables: 2, missingIndexes: []string{"urls_plain_udx"},
+			wantErr: "database structure is not migrated: missing indexes urls_plain_udx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := new(DBHandlerMock)
+			h.On("QueryRow", database.CountDatabaseTables, []interface{}(nil)).Return(&RowMock{Values: []interface{}{tt.tables}}, nil)
+			h.On("QueryRow", database.MissingURLColumns, []interface{}{database.URLColumns}).Return(&RowMock{Values: []interface{}{tt.missingColumns}}, nil)
+			h.On("QueryRow", database.MissingIndexes, []interface{}{database.DatabaseIndexes}).Return(&RowMock{Values: []interface{}{tt.missingIndexes}}, nil)
+			err := CheckDatabaseStructure(context.Background(), h)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"github.com/da-semenov/go-short-url/internal/app/database"
+	"github.com/da-semenov/go-short-url/internal/app/storage/basedbhandler"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// maxPayloadSize keeps NOTIFY payloads under the 8000 bytes postgres accepts.
+const maxPayloadSize = 7900
+
+// purgeAll is sent instead of a code list when a code doesn't fit in one payload.
+const purgeAll = "*"
+
+const listenRetryInterval = time.Second
+
+// invalidationPayloads packs newline-separated short codes into NOTIFY payloads.
+func invalidationPayloads(codes []string) []string {
+	var res []string
+	var b strings.Builder
+	for _, code := range codes {
+		if code == "" {
+			continue
+		}
+		if len(code) > maxPayloadSize {
+			return []string{purgeAll}
+		}
+		if b.Len() > 0 && b.Len()+1+len(code) > maxPayloadSize {
+			res = append(res, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(code)
+	}
+	if b.Len() > 0 {
+		res = append(res, b.String())
+	}
+	return res
+}
+
+// notifyInvalidation publishes changed codes to every instance. Called inside
+// a transaction, the notification is delivered only if the change commits.
+func notifyInvalidation(ctx context.Context, tx basedbhandler.DBHandler, codes []string) error {
+	for _, payload := range invalidationPayloads(codes) {
+		err := tx.Execute(ctx, database.NotifyInvalidation, payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidationListener keeps a LISTEN connection from the pool and evicts the
+// codes changed by other instances from the local cache.
+type InvalidationListener struct {
+	pool          *pgxpool.Pool
+	cache         *URLCache
+	retryInterval time.Duration
+	log           *zap.Logger
+}
+
+func NewInvalidationListener(handler *PostgresHandler, cache *URLCache, log *zap.Logger) (*InvalidationListener, error) {
+	var l InvalidationListener
+	l.pool = handler.pool
+	l.cache = cache
+	l.log = log
+	l.retryInterval = listenRetryInterval
+	return &l, nil
+}
+
+// Run listens until ctx is cancelled. Notifications sent while the connection
+// was down are lost, so the whole cache is flushed on every (re)connect.
+func (l *InvalidationListener) Run(ctx context.Context) {
+	for {
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		l.log.Warn("invalidation listener: connection lost, cache flushed", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+func (l *InvalidationListener) listen(ctx context.Context) error {
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	_, err = conn.Exec(ctx, database.ListenInvalidation)
+	if err != nil {
+		return err
+	}
+	l.cache.Purge()
+	l.log.Debug("invalidation listener: listening", zap.String("channel", database.InvalidationChannel))
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		l.handle(n.Payload)
+	}
+}
+
+func (l *InvalidationListener) handle(payload string) {
+	if payload == purgeAll {
+		l.cache.Purge()
+		return
+	}
+	l.cache.Invalidate(strings.Split(payload, "\n")...)
+}
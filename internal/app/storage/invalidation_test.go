@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/da-semenov/go-short-url/internal/app/database"
+	"github.com/da-semenov/go-short-url/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvalidationPayloads(t *testing.T) {
+	long := strings.Repeat("a", maxPayloadSize/2)
+	tests := []struct {
+		name  string
+		codes []string
+		want  []string
+	}{
+		{name: "Test 1. One payload.", codes: []string{"a", "", "b"}, want: []string{"a\nb"}},
+		{name: "Test 2. Split by size.", codes: []string{long, long, "c"}, want: []string{long, long + "\nc"}},
+		{name: "Test 3. Code too long.", codes: []string{"a", strings.Repeat("a", maxPayloadSize+1)}, want: []string{purgeAll}},
+		{name: "Test 4. Empty.", codes: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, invalidationPayloads(tt.codes))
+		})
+	}
+}
+
+func TestInvalidationListener_handle(t *testing.T) {
+	cache := NewURLCache(10, time.Minute, time.Minute)
+	l := &InvalidationListener{cache: cache}
+	for _, k := range []string{"a", "b", "c"} {
+		cache.Set(cache.Generation(), k, models.Target{OriginalURL: "url_" + k})
+	}
+
+	l.handle("a\nb")
+	assert.Equal(t, 1, cache.Stats().Size)
+	l.handle(purgeAll)
+	assert.Equal(t, 0, cache.Stats().Size)
+}
+
+func TestDeleteRepository_BatchDelete(t *testing.T) {
+	h := new(DBHandlerMock)
+	h.On("ExecuteBatch", database.DeleteUserURL, [][]interface{}{{"user_id", "a"}, {"user_id", "b"}}).Return(nil, nil)
+	h.On("Execute", database.NotifyInvalidation, []interface{}{"a\nb"}).Return(errors.New("notify failed"))
+	repo, _ := NewDeleteRepository(h)
+
+	err := repo.BatchDelete(context.Background(), "user_id", []string{"a", "", "b"})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, h.RolledBack, "delete must be rolled back when the event can't be published")
+}
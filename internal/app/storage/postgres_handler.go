@@ -2,93 +2,114 @@ package storage
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/da-semenov/go-short-url/internal/app/storage/basedbhandler"
+	"github.com/da-semenov/go-short-url/internal/app/tracing"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
 )
 
+var tracer = tracing.Tracer("github.com/da-semenov/go-short-url/internal/app/storage")
+
 type PostgresHandler struct {
 	pool *pgxpool.Pool
 }
 
-type PostgresRow struct {
-	Rows *pgx.Row
+type batchSender interface {
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
 }
 
-func (handler *PostgresHandler) Execute(ctx context.Context, statement string, args ...interface{}) error {
+// acquire takes a connection from the pool in its own span, so the time
+// spent waiting for the pool is visible apart from the query.
+func (handler *PostgresHandler) acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	ctx, span := tracer.Start(ctx, "pgxpool.Acquire")
+	defer span.End()
 	conn, err := handler.pool.Acquire(ctx)
+	tracing.RecordError(span, err)
+	return conn, err
+}
+
+func (handler *PostgresHandler) Execute(ctx context.Context, statement string, args ...interface{}) error {
+	ctx, span := tracer.Start(ctx, "PostgresHandler.Execute", tracing.DBAttributes(statement))
+	defer span.End()
+	conn, err := handler.acquire(ctx)
 	if err != nil {
 		return err
 	}
 	defer conn.Release()
-	if len(args) > 0 {
-		_, err = conn.Exec(ctx, statement, args...)
-	} else {
-		_, err = conn.Exec(ctx, statement)
-	}
+	_, err = conn.Exec(ctx, statement, args...)
+	tracing.RecordError(span, err)
 	return err
 }
 
-func (handler *PostgresHandler) ExecuteBatch(ctx context.Context, statement string, args [][]interface{}) error {
-	conn, err := handler.pool.Acquire(ctx)
+func (handler *PostgresHandler) ExecuteBatch(ctx context.Context, statement string, args [][]interface{}) (basedbhandler.BatchResult, error) {
+	ctx, span := tracer.Start(ctx, "PostgresHandler.ExecuteBatch", tracing.DBAttributes(statement))
+	defer span.End()
+	conn, err := handler.acquire(ctx)
 	if err != nil {
-		return err
+		return basedbhandler.BatchResult{}, err
 	}
 	defer conn.Release()
-
-	batch := &pgx.Batch{}
-	if len(args) > 0 {
-		for _, argset := range args {
-			batch.Queue(statement, argset...)
-		}
-	} else {
-		return nil
-	}
-	br := conn.SendBatch(context.Background(), batch)
-	ct, err := br.Exec()
-	if err != nil {
-		return err
-	}
-	fmt.Println(ct.RowsAffected())
-	return nil
+	res, err := executeBatch(ctx, conn, statement, args)
+	tracing.RecordError(span, err)
+	return res, err
 }
 
+// QueryRow keeps the connection and the span until the row is scanned, so the
+// row must always be scanned.
 func (handler *PostgresHandler) QueryRow(ctx context.Context, statement string, args ...interface{}) (basedbhandler.Row, error) {
-	var row pgx.Row
-	conn, err := handler.pool.Acquire(ctx)
+	ctx, span := tracer.Start(ctx, "PostgresHandler.QueryRow", tracing.DBAttributes(statement))
+	conn, err := handler.acquire(ctx)
 	if err != nil {
+		span.End()
 		return nil, err
 	}
-	defer conn.Release()
-
-	if len(args) > 0 {
-		row = conn.QueryRow(ctx, statement, args...)
-	} else {
-		row = conn.QueryRow(ctx, statement)
-	}
-
-	return row, nil
+	return &connRow{row: conn.QueryRow(ctx, statement, args...), span: span, release: conn.Release}, nil
 }
 
+// Query keeps the connection until the rows are closed or read to the end.
 func (handler *PostgresHandler) Query(ctx context.Context, statement string, args ...interface{}) (basedbhandler.Rows, error) {
-	var rows pgx.Rows
-
-	conn, err := handler.pool.Acquire(ctx)
-	defer conn.Release()
+	ctx, span := tracer.Start(ctx, "PostgresHandler.Query", tracing.DBAttributes(statement))
+	defer span.End()
+	conn, err := handler.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, statement, args...)
 	if err != nil {
+		conn.Release()
+		tracing.RecordError(span, err)
 		return nil, err
 	}
+	return &connRows{Rows: rows, conn: conn}, nil
+}
 
-	if len(args) > 0 {
-		rows, err = conn.Query(ctx, statement, args...)
-	} else {
-		rows, err = conn.Query(ctx, statement)
+func (handler *PostgresHandler) WithTx(ctx context.Context, fn func(tx basedbhandler.DBHandler) error) error {
+	ctx, span := tracer.Start(ctx, "PostgresHandler.WithTx")
+	defer span.End()
+	conn, err := handler.acquire(ctx)
+	if err != nil {
+		return err
 	}
+	defer conn.Release()
+	tx, err := conn.Begin(ctx)
 	if err != nil {
-		return nil, err
+		tracing.RecordError(span, err)
+		return err
 	}
-	return rows, nil
+	err = runTx(ctx, tx, fn)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (handler *PostgresHandler) Ping(ctx context.Context) error {
+	return handler.pool.Ping(ctx)
+}
+
+func (handler *PostgresHandler) Stat() *pgxpool.Stat {
+	return handler.pool.Stat()
 }
 
 func (handler *PostgresHandler) Close() {
@@ -110,3 +131,129 @@ func NewPostgresHandler(ctx context.Context, dataSource string) (*PostgresHandle
 	postgresHandler.pool = pool
 	return postgresHandler, nil
 }
+
+// connRows returns the connection to the pool once the rows are done.
+type connRows struct {
+	pgx.Rows
+	conn *pgxpool.Conn
+	once sync.Once
+}
+
+func (r *connRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.Close()
+	return false
+}
+
+func (r *connRows) Close() {
+	r.once.Do(func() {
+		r.Rows.Close()
+		r.conn.Release()
+	})
+}
+
+// connRow returns the connection to the pool, if it has one of its own, and
+// ends the span of the query after Scan. The values are decoded by pgx, so
+// every type it knows can be scanned, numeric included.
+type connRow struct {
+	row     pgx.Row
+	span    trace.Span
+	release func()
+}
+
+func (r *connRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if r.release != nil {
+		r.release()
+	}
+	// no rows is an answer, not a failure of the query
+	if !errors.Is(err, pgx.ErrNoRows) {
+		tracing.RecordError(r.span, err)
+	}
+	r.span.End()
+	return err
+}
+
+// executeBatch sends all statements in one round trip and reads the result of
+// each of them, so a failure is reported for the statement that caused it.
+func executeBatch(ctx context.Context, sender batchSender, statement string, args [][]interface{}) (basedbhandler.BatchResult, error) {
+	var res basedbhandler.BatchResult
+	if len(args) == 0 {
+		return res, nil
+	}
+	batch := &pgx.Batch{}
+	for _, argset := range args {
+		batch.Queue(statement, argset...)
+	}
+	br := sender.SendBatch(ctx, batch)
+	res.RowsAffected = make([]int64, 0, len(args))
+	for i, argset := range args {
+		ct, err := br.Exec()
+		if err != nil {
+			br.Close()
+			return res, &basedbhandler.BatchError{Index: i, Args: argset, Err: err}
+		}
+		res.RowsAffected = append(res.RowsAffected, ct.RowsAffected())
+	}
+	return res, br.Close()
+}
+
+type PostgresTx struct {
+	tx pgx.Tx
+}
+
+func runTx(ctx context.Context, tx pgx.Tx, fn func(tx basedbhandler.DBHandler) error) error {
+	defer tx.Rollback(ctx)
+	err := fn(&PostgresTx{tx: tx})
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (handler *PostgresTx) Execute(ctx context.Context, statement string, args ...interface{}) error {
+	ctx, span := tracer.Start(ctx, "PostgresTx.Execute", tracing.DBAttributes(statement))
+	defer span.End()
+	_, err := handler.tx.Exec(ctx, statement, args...)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (handler *PostgresTx) ExecuteBatch(ctx context.Context, statement string, args [][]interface{}) (basedbhandler.BatchResult, error) {
+	ctx, span := tracer.Start(ctx, "PostgresTx.ExecuteBatch", tracing.DBAttributes(statement))
+	defer span.End()
+	res, err := executeBatch(ctx, handler.tx, statement, args)
+	tracing.RecordError(span, err)
+	return res, err
+}
+
+func (handler *PostgresTx) Query(ctx context.Context, statement string, args ...interface{}) (basedbhandler.Rows, error) {
+	ctx, span := tracer.Start(ctx, "PostgresTx.Query", tracing.DBAttributes(statement))
+	defer span.End()
+	rows, err := handler.tx.Query(ctx, statement, args...)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (handler *PostgresTx) QueryRow(ctx context.Context, statement string, args ...interface{}) (basedbhandler.Row, error) {
+	ctx, span := tracer.Start(ctx, "PostgresTx.QueryRow", tracing.DBAttributes(statement))
+	return &connRow{row: handler.tx.QueryRow(ctx, statement, args...), span: span}, nil
+}
+
+// WithTx opens a savepoint inside the current transaction.
+func (handler *PostgresTx) WithTx(ctx context.Context, fn func(tx basedbhandler.DBHandler) error) error {
+	tx, err := handler.tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	return runTx(ctx, tx, fn)
+}
+
+// Close is a no-op: the transaction is finished by WithTx.
+func (handler *PostgresTx) Close() {
+}
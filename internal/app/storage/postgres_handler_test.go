@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/da-semenov/go-short-url/internal/app/storage/basedbhandler"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+type batchResultsStub struct {
+	results []error
+	next    int
+	closed  bool
+}
+
+func (b *batchResultsStub) Exec() (pgconn.CommandTag, error) {
+	err := b.results[b.next]
+	b.next++
+	if err != nil {
+		return nil, err
+	}
+	return pgconn.CommandTag("UPDATE 1"), nil
+}
+
+func (b *batchResultsStub) Query() (pgx.Rows, error) {
+	return nil, errors.New("unexpected call")
+}
+
+func (b *batchResultsStub) QueryRow() pgx.Row {
+	return nil
+}
+
+func (b *batchResultsStub) QueryFunc(scans []interface{}, f func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	return nil, errors.New("unexpected call")
+}
+
+func (b *batchResultsStub) Close() error {
+	b.closed = true
+	return nil
+}
+
+type batchSenderStub struct {
+	br     *batchResultsStub
+	queued int
+}
+
+func (s *batchSenderStub) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	s.queued = b.Len()
+	return s.br
+}
+
+func TestExecuteBatch(t *testing.T) {
+	failure := errors.New("statement failed")
+	tests := []struct {
+		name      string
+		args      [][]interface{}
+		results   []error
+		wantRows  []int64
+		wantIndex int
+		wantErr   bool
+	}{
+		{name: "Test 1. Batch. Positive.",
+			args:     [][]interface{}{{"u", "a"}, {"u", "b"}},
+			results:  []error{nil, nil},
+			wantRows: []int64{1, 1},
+		},
+		{name: "Test 2. Batch. Second statement fails.",
+			args:      [][]interface{}{{"u", "a"}, {"u", "b"}, {"u", "c"}},
+			results:   []error{nil, failure, nil},
+			wantRows:  []int64{1},
+			wantIndex: 1,
+			wantErr:   true,
+		},
+		{name: "Test 3. Batch. Empty.",
+			args:    nil,
+			results: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &batchSenderStub{br: &batchResultsStub{results: tt.results}}
+			res, err := executeBatch(context.Background(), sender, "statement", tt.args)
+
+			assert.Equal(t, tt.wantRows, res.RowsAffected)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			var batchErr *basedbhandler.BatchError
+			assert.True(t, errors.As(err, &batchErr))
+			assert.Equal(t, tt.wantIndex, batchErr.Index)
+			assert.Equal(t, tt.args[tt.wantIndex], batchErr.Args)
+			assert.ErrorIs(t, err, failure)
+			assert.True(t, sender.br.closed)
+		})
+	}
+}
+
+// numericRow decodes a numeric column in text format, as pgx does.
+type numericRow struct {
+	text string
+}
+
+func (r numericRow) Scan(dest ...interface{}) error {
+	return pgtype.NewConnInfo().Scan(pgtype.NumericOID, pgx.TextFormatCode, []byte(r.text), dest[0])
+}
+
+func TestConnRow(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	released := 0
+	_, span := tracer.Start(context.Background(), "QueryRow")
+	row := &connRow{row: numericRow{text: "7"}, span: span, release: func() { released++ }}
+	assert.Empty(t, recorder.Ended(), "the span must last until Scan")
+
+	// urls.id and user_urls.url_id are numeric
+	var id int64
+	assert.NoError(t, row.Scan(&id))
+	assert.Equal(t, int64(7), id)
+	assert.Equal(t, 1, released)
+	assert.Len(t, recorder.Ended(), 1)
+
+	_, span = tracer.Start(context.Background(), "QueryRow")
+	row = &connRow{row: numericRow{text: "7.5"}, span: span, release: func() { released++ }}
+	assert.Error(t, row.Scan(&id))
+	assert.Equal(t, 2, released, "the connection must be released when Scan fails too")
+	assert.Len(t, recorder.Ended(), 2)
+}
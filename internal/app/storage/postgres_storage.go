@@ -2,12 +2,15 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/da-semenov/go-short-url/internal/app/database"
 	"github.com/da-semenov/go-short-url/internal/app/models"
 	"github.com/da-semenov/go-short-url/internal/app/storage/basedbhandler"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
+	"sort"
 )
 
 type PostgresRepository struct {
@@ -38,6 +41,7 @@ func (r *PostgresRepository) FindByUser(ctx context.Context, userID string) ([]m
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	var resArr []models.UserURLs
 	for rows.Next() {
 		var rec models.UserURLs
@@ -47,42 +51,76 @@ func (r *PostgresRepository) FindByUser(ctx context.Context, userID string) ([]m
 			return nil, err
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return resArr, nil
 }
 
-func (r *PostgresRepository) Save(ctx context.Context, userID string, originalURL string, shortURL string) error {
-	err := r.handler.Execute(ctx, database.InsertURL, userID, nil, originalURL, shortURL)
+func insertUserURL(ctx context.Context, tx basedbhandler.DBHandler, userID string, e models.Element) error {
+	var correlationID, title interface{}
+	if e.CorrelationID != "" {
+		correlationID = e.CorrelationID
+	}
+	if e.Title != "" {
+		title = e.Title
+	}
+	rules, err := encodeRules(e.Rules)
+	if err != nil {
+		return err
+	}
+	row, err := tx.QueryRow(ctx, database.InsertURL, correlationID, e.OriginalURL, e.ShortURL, title, e.RedirectType,
+		e.QueryPassthrough, e.UTM.Source, e.UTM.Medium, e.UTM.Campaign, e.UTM.Conflict, e.PasswordHash, clicksLeft(e),
+		e.ActiveFrom, e.ActiveUntil, rules, e.OwnCode)
+	if err != nil {
+		return err
+	}
+	var urlID int64
+	err = row.Scan(&urlID)
+	if err != nil {
+		return err
+	}
+	return tx.Execute(ctx, database.InsertUserURL, urlID, userID)
+}
+
+func mapUniqueViolation(err error) error {
 	var pgErr *pgconn.PgError
 	if errors.As(err, &pgErr) {
 		if pgErr.Code == pgerrcode.UniqueViolation {
 			return &models.UniqueViolation
 		}
 	}
+	return err
+}
+
+func (r *PostgresRepository) Save(ctx context.Context, userID string, e models.Element) error {
+	err := r.handler.WithTx(ctx, func(tx basedbhandler.DBHandler) error {
+		err := insertUserURL(ctx, tx, userID, e)
+		if err != nil {
+			return err
+		}
+		return notifyInvalidation(ctx, tx, []string{e.ShortURL})
+	})
 	if err != nil {
-		return err
+		return mapUniqueViolation(err)
 	}
 	return nil
 }
 
 func (r *PostgresRepository) SaveBatch(ctx context.Context, src models.UserBatchURLs) error {
-	var paramArr [][]interface{}
-	for _, obj := range src.List {
-		var paramLine []interface{}
-		paramLine = append(paramLine, src.UserID)
-		paramLine = append(paramLine, obj.CorrelationID)
-		paramLine = append(paramLine, obj.OriginalURL)
-		paramLine = append(paramLine, obj.ShortURL)
-		paramArr = append(paramArr, paramLine)
-	}
-	err := r.handler.ExecuteBatch(ctx, database.InsertURL, paramArr)
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) {
-		if pgErr.Code == pgerrcode.UniqueViolation {
-			return &models.UniqueViolation
+	err := r.handler.WithTx(ctx, func(tx basedbhandler.DBHandler) error {
+		codes := make([]string, 0, len(src.List))
+		for _, obj := range src.List {
+			err := insertUserURL(ctx, tx, src.UserID, obj)
+			if err != nil {
+				return err
+			}
+			codes = append(codes, obj.ShortURL)
 		}
-	}
+		return notifyInvalidation(ctx, tx, codes)
+	})
 	if err != nil {
-		return err
+		return mapUniqueViolation(err)
 	}
 	return nil
 }
@@ -109,3 +147,186 @@ func (r *PostgresRepository) FindByShort(ctx context.Context, userID string, sho
 	}
 	return res, nil
 }
+
+func (r *PostgresRepository) GetStats(ctx context.Context) (*models.Stats, error) {
+	var res models.Stats
+	row, err := r.handler.QueryRow(ctx, database.CountURLs)
+	if err != nil {
+		return nil, err
+	}
+	err = row.Scan(&res.URLs)
+	if err != nil {
+		return nil, err
+	}
+	row, err = r.handler.QueryRow(ctx, database.CountUsers)
+	if err != nil {
+		return nil, err
+	}
+	err = row.Scan(&res.Users)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (r *PostgresRepository) CountByUser(ctx context.Context, userID string) (int, error) {
+	row, err := r.handler.QueryRow(ctx, database.CountUserURLs, userID)
+	if err != nil {
+		return 0, err
+	}
+	var res int
+	err = row.Scan(&res)
+	if err != nil {
+		return 0, err
+	}
+	return res, nil
+}
+
+func (r *PostgresRepository) FindLink(ctx context.Context, shortURL string) (*models.Link, error) {
+	row, err := r.handler.QueryRow(ctx, database.GetLinkByShort, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	res := models.Link{ShortURL: shortURL}
+	err = row.Scan(&res.OriginalURL, &res.Title, &res.CreatedAt, &res.Clicks, &res.PasswordHash, &res.ClicksLeft, &res.ActiveFrom, &res.ActiveUntil)
+	if err != nil && err.Error() == "no rows in result set" {
+		return nil, &models.NoRowFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (r *PostgresRepository) FindTarget(ctx context.Context, shortURL string) (*models.Target, error) {
+	row, err := r.handler.QueryRow(ctx, database.GetTargetByShort, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	var res models.Target
+	var rules string
+	err = row.Scan(&res.OriginalURL, &res.RedirectType, &res.QueryPassthrough, &res.UTM.Source, &res.UTM.Medium, &res.UTM.Campaign, &res.UTM.Conflict,
+		&res.PasswordHash, &res.Limited, &res.ActiveFrom, &res.ActiveUntil, &rules)
+	if err != nil && err.Error() == "no rows in result set" {
+		return nil, &models.NoRowFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rules != "" {
+		if err = json.Unmarshal([]byte(rules), &res.Rules); err != nil {
+			return nil, fmt.Errorf("can't decode rules of %s: %w", shortURL, err)
+		}
+	}
+	return &res, nil
+}
+
+// encodeRules makes the value of the rules column; no rules is NULL.
+func encodeRules(rules []models.Rule) (interface{}, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// UpdateLink replaces the options of a link the user owns. A plain link is
+// SharedLink: its code is handed out to everyone who shortens the URL, so
+// options set on it would apply to their links too.
+func (r *PostgresRepository) UpdateLink(ctx context.Context, userID string, e models.Element) error {
+	var title interface{}
+	if e.Title != "" {
+		title = e.Title
+	}
+	var passwordHash interface{}
+	if !e.KeepPassword {
+		passwordHash = e.PasswordHash
+	}
+	rules, err := encodeRules(e.Rules)
+	if err != nil {
+		return err
+	}
+	return r.handler.WithTx(ctx, func(tx basedbhandler.DBHandler) error {
+		row, err := tx.QueryRow(ctx, database.LockLink, userID, e.ShortURL)
+		if err != nil {
+			return err
+		}
+		var ownCode bool
+		err = row.Scan(&ownCode)
+		if err != nil && err.Error() == "no rows in result set" {
+			return &models.NoRowFound
+		}
+		if err != nil {
+			return err
+		}
+		if !ownCode {
+			return &models.SharedLink
+		}
+		row, err = tx.QueryRow(ctx, database.UpdateLink, userID, e.ShortURL, title, e.RedirectType,
+			e.QueryPassthrough, e.UTM.Source, e.UTM.Medium, e.UTM.Campaign, e.UTM.Conflict, passwordHash, clicksLeft(e),
+			e.ActiveFrom, e.ActiveUntil, rules, e.KeepClicks)
+		if err != nil {
+			return err
+		}
+		var id int64
+		err = row.Scan(&id)
+		if err != nil && err.Error() == "no rows in result set" {
+			return &models.NoRowFound
+		}
+		if err != nil {
+			return err
+		}
+		return notifyInvalidation(ctx, tx, []string{e.ShortURL})
+	})
+}
+
+// clicksLeft is the initial click budget of e; nil means no limit.
+func clicksLeft(e models.Element) interface{} {
+	if e.MaxClicks > 0 {
+		return e.MaxClicks
+	}
+	return nil
+}
+
+// AddClicks adds up the clicks in one batch. Codes are updated in order, so
+// replicas flushing at the same time can't deadlock on the rows.
+func (r *PostgresRepository) AddClicks(ctx context.Context, clicks map[string]int64) error {
+	codes := make([]string, 0, len(clicks))
+	for shortURL := range clicks {
+		codes = append(codes, shortURL)
+	}
+	sort.Strings(codes)
+	args := make([][]interface{}, 0, len(codes))
+	for _, shortURL := range codes {
+		args = append(args, []interface{}{shortURL, clicks[shortURL]})
+	}
+	_, err := r.handler.ExecuteBatch(ctx, database.AddClicks, args)
+	return err
+}
+
+// TakeClick uses up one click of a click-limited link. NoRowFound means it
+// has none left. The last click tells the other replicas to drop the link
+// from their caches.
+func (r *PostgresRepository) TakeClick(ctx context.Context, shortURL string) error {
+	return r.handler.WithTx(ctx, func(tx basedbhandler.DBHandler) error {
+		row, err := tx.QueryRow(ctx, database.TakeClick, shortURL)
+		if err != nil {
+			return err
+		}
+		var left int64
+		err = row.Scan(&left)
+		if err != nil && err.Error() == "no rows in result set" {
+			return &models.NoRowFound
+		}
+		if err != nil {
+			return err
+		}
+		if left > 0 {
+			return nil
+		}
+		return notifyInvalidation(ctx, tx, []string{shortURL})
+	})
+}
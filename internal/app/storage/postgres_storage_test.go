@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/da-semenov/go-short-url/internal/app/database"
+	"github.com/da-semenov/go-short-url/internal/app/models"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestPostgresRepository_Save(t *testing.T) {
+	tests := []struct {
+		name         string
+		insertErr    error
+		wantErr      error
+		wantCommit   int
+		wantRollback int
+	}{
+		{name: "Test 1. Save. Positive.", insertErr: nil, wantErr: nil, wantCommit: 1},
+		{name: "Test 2. Save. Duplicate in user_urls.", insertErr: &pgconn.PgError{Code: pgerrcode.UniqueViolation}, wantErr: &models.UniqueViolation, wantRollback: 1},
+		{name: "Test 3. Save. Other error.", insertErr: errors.New("connection lost"), wantErr: errors.New("connection lost"), wantRollback: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := new(DBHandlerMock)
+			h.On("QueryRow", database.InsertURL, mock.Anything).Return(&RowMock{Values: []interface{}{int64(7)}}, nil)
+			h.On("Execute", database.InsertUserURL, []interface{}{int64(7), "user_id"}).Return(tt.insertErr)
+			h.On("Execute", database.NotifyInvalidation, []interface{}{"short_URL"}).Return(nil)
+			repo, _ := NewPostgresRepository(h)
+
+			err := repo.Save(context.Background(), "user_id", models.Element{OriginalURL: "original_URL", ShortURL: "short_URL"})
+
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantCommit, h.Committed)
+			assert.Equal(t, tt.wantRollback, h.RolledBack)
+		})
+	}
+}
+
+func TestPostgresRepository_SaveBatch(t *testing.T) {
+	from := time.Date(2022, 6, 1, 9, 0, 0, 0, time.UTC)
+	h := new(DBHandlerMock)
+	h.On("QueryRow", database.InsertURL, []interface{}{"c1", "url_1", "short_1", "Title 1", 0, true, "news", "", "", "", "hash_1", 3, &from, (*time.Time)(nil), `[{"platform":"ios","url":"https://apps.example/"}]`, true}).Return(&RowMock{Values: []interface{}{int64(1)}}, nil)
+	h.On("QueryRow", database.InsertURL, []interface{}{"c2", "url_2", "short_2", nil, 0, false, "", "", "", "", "", nil, (*time.Time)(nil), (*time.Time)(nil), nil, false}).Return(&RowMock{Values: []interface{}{int64(2)}}, nil)
+	h.On("Execute", database.InsertUserURL, []interface{}{int64(1), "user_id"}).Return(nil)
+	h.On("Execute", database.InsertUserURL, []interface{}{int64(2), "user_id"}).Return(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	repo, _ := NewPostgresRepository(h)
+
+	err := repo.SaveBatch(context.Background(), models.UserBatchURLs{UserID: "user_id", List: []models.Element{
+		{CorrelationID: "c1", OriginalURL: "url_1", ShortURL: "short_1", Title: "Title 1", QueryPassthrough: true, UTM: models.UTM{Source: "news"}, PasswordHash: "hash_1", MaxClicks: 3, ActiveFrom: &from,
+			Rules: []models.Rule{{Platform: "ios", URL: "https://apps.example/"}}, OwnCode: true},
+		{CorrelationID: "c2", OriginalURL: "url_2", ShortURL: "short_2"},
+	}})
+
+	assert.Equal(t, &models.UniqueViolation, err)
+	assert.Equal(t, 0, h.Committed)
+	assert.Equal(t, 1, h.RolledBack)
+}
+
+func TestPostgresRepository_GetStats(t *testing.T) {
+	h := new(DBHandlerMock)
+	h.On("QueryRow", database.CountURLs, []interface{}(nil)).Return(&RowMock{Values: []interface{}{12}}, nil)
+	h.On("QueryRow", database.CountUsers, []interface{}(nil)).Return(&RowMock{Values: []interface{}{3}}, nil)
+	repo, _ := NewPostgresRepository(h)
+
+	res, err := repo.GetStats(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, &models.Stats{URLs: 12, Users: 3}, res)
+}
+
+func TestPostgresRepository_FindLink(t *testing.T) {
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	until := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	h := new(DBHandlerMock)
+	h.On("QueryRow", database.GetLinkByShort, []interface{}{"short_URL"}).
+		Return(&RowMock{Values: []interface{}{"original_URL", "Title", created, int64(5), "hash", int64(4), nil, &until}}, nil)
+	h.On("QueryRow", database.GetLinkByShort, []interface{}{"badURL"}).
+		Return(&RowMock{Err: errors.New("no rows in result set")}, nil)
+	repo, _ := NewPostgresRepository(h)
+
+	res, err := repo.FindLink(context.Background(), "short_URL")
+	assert.NoError(t, err)
+	assert.Equal(t, &models.Link{ShortURL: "short_URL", OriginalURL: "original_URL", Title: "Title", CreatedAt: created, Clicks: 5, PasswordHash: "hash", ClicksLeft: 4, ActiveUntil: &until}, res)
+
+	_, err = repo.FindLink(context.Background(), "badURL")
+	assert.Equal(t, &models.NoRowFound, err)
+}
+
+func TestPostgresRepository_UpdateLink(t *testing.T) {
+	h := new(DBHandlerMock)
+	h.On("QueryRow", database.LockLink, []interface{}{"user_id", "short_URL"}).Return(&RowMock{Values: []interface{}{true}}, nil)
+	h.On("QueryRow", database.LockLink, []interface{}{"other_user", "short_URL"}).Return(&RowMock{Err: errors.New("no rows in result set")}, nil)
+	h.On("QueryRow", database.LockLink, []interface{}{"plain_user", "plain_URL"}).Return(&RowMock{Values: []interface{}{false}}, nil)
+	h.On("QueryRow", database.LockLink, []interface{}{"user_id", "kept_URL"}).Return(&RowMock{Values: []interface{}{true}}, nil)
+	h.On("QueryRow", database.UpdateLink, []interface{}{"user_id", "kept_URL", nil, 0, false, "", "", "", "", nil, nil, (*time.Time)(nil), (*time.Time)(nil), nil, true}).Return(&RowMock{Values: []interface{}{int64(8)}}, nil)
+	h.On("Execute", database.NotifyInvalidation, []interface{}{"kept_URL"}).Return(nil)
+	h.On("QueryRow", database.UpdateLink, []interface{}{"user_id", "short_URL", "Title", 308, true, "news", "email", "spring", "override", "hash", 10, (*time.Time)(nil), (*time.Time)(nil), nil, false}).Return(&RowMock{Values: []interface{}{int64(7)}}, nil)
+	h.On("Execute", database.NotifyInvalidation, []interface{}{"short_URL"}).Return(nil)
+	repo, _ := NewPostgresRepository(h)
+
+	err := repo.UpdateLink(context.Background(), "user_id", models.Element{ShortURL: "short_URL", Title: "Title", RedirectType: 308,
+		QueryPassthrough: true, UTM: models.UTM{Source: "news", Medium: "email", Campaign: "spring", Conflict: "override"}, PasswordHash: "hash", MaxClicks: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, h.Committed)
+
+	err = repo.UpdateLink(context.Background(), "other_user", models.Element{ShortURL: "short_URL"})
+	assert.Equal(t, &models.NoRowFound, err)
+	assert.Equal(t, 1, h.RolledBack)
+
+	err = repo.UpdateLink(context.Background(), "plain_user", models.Element{ShortURL: "plain_URL"})
+	assert.Equal(t, &models.SharedLink, err)
+	assert.Equal(t, 2, h.RolledBack)
+	// the three locks and the one update of user_id
+	h.AssertNumberOfCalls(t, "QueryRow", 4)
+
+	// the password and the clicks left are left out of the update
+	err = repo.UpdateLink(context.Background(), "user_id", models.Element{ShortURL: "kept_URL", KeepPassword: true, KeepClicks: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, h.Committed)
+}
+
+func TestPostgresRepository_TakeClick(t *testing.T) {
+	h := new(DBHandlerMock)
+	h.On("QueryRow", database.TakeClick, []interface{}{"two_left"}).Return(&RowMock{Values: []interface{}{int64(1)}}, nil)
+	h.On("QueryRow", database.TakeClick, []interface{}{"one_left"}).Return(&RowMock{Values: []interface{}{int64(0)}}, nil)
+	h.On("QueryRow", database.TakeClick, []interface{}{"none_left"}).Return(&RowMock{Err: errors.New("no rows in result set")}, nil)
+	h.On("Execute", database.NotifyInvalidation, []interface{}{"one_left"}).Return(nil)
+	repo, _ := NewPostgresRepository(h)
+
+	assert.NoError(t, repo.TakeClick(context.Background(), "two_left"))
+	h.AssertNotCalled(t, "Execute", database.NotifyInvalidation, []interface{}{"two_left"})
+	assert.NoError(t, repo.TakeClick(context.Background(), "one_left"))
+	h.AssertCalled(t, "Execute", database.NotifyInvalidation, []interface{}{"one_left"})
+	assert.Equal(t, &models.NoRowFound, repo.TakeClick(context.Background(), "none_left"))
+	assert.Equal(t, 2, h.Committed)
+	assert.Equal(t, 1, h.RolledBack)
+}
+
+func TestPostgresRepository_AddClicks(t *testing.T) {
+	h := new(DBHandlerMock)
+	h.On("ExecuteBatch", database.AddClicks, [][]interface{}{{"a", int64(1)}, {"b", int64(7)}, {"c", int64(2)}}).Return(nil, nil)
+	repo, _ := NewPostgresRepository(h)
+
+	err := repo.AddClicks(context.Background(), map[string]int64{"c": 2, "a": 1, "b": 7})
+	assert.NoError(t, err)
+	h.AssertExpectations(t)
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"container/list"
+	"github.com/da-semenov/go-short-url/internal/app/models"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// URLCache is a size-bounded LRU of short code -> redirect target with TTL.
+// Misses are cached too (with their own TTL), so unknown codes don't reach the database.
+type URLCache struct {
+	sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	items       map[string]*list.Element
+	order       *list.List
+	now         func() time.Time
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	generation  uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   models.Target
+	found   bool
+	expires time.Time
+}
+
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+func NewURLCache(size int, ttl time.Duration, negativeTTL time.Duration) *URLCache {
+	var c URLCache
+	c.size = size
+	c.ttl = ttl
+	c.negativeTTL = negativeTTL
+	c.items = make(map[string]*list.Element)
+	c.order = list.New()
+	c.now = time.Now
+	return &c
+}
+
+// Get returns the cached value and whether the code exists. ok is false when
+// the code is not cached and has to be looked up.
+func (c *URLCache) Get(key string) (value models.Target, found bool, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, exists := c.items[key]
+	if !exists {
+		atomic.AddUint64(&c.misses, 1)
+		return models.Target{}, false, false
+	}
+	e := el.Value.(*cacheEntry)
+	if c.now().After(e.expires) {
+		c.removeElement(el)
+		atomic.AddUint64(&c.misses, 1)
+		return models.Target{}, false, false
+	}
+	c.order.MoveToFront(el)
+	atomic.AddUint64(&c.hits, 1)
+	return e.value, e.found, true
+}
+
+// Generation changes on every invalidation. A value read from the database is
+// stored only if the generation taken before the read is still current, so a
+// delete running concurrently with the read can't leave a stale entry behind.
+func (c *URLCache) Generation() uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.generation
+}
+
+func (c *URLCache) Set(gen uint64, key string, value models.Target) {
+	c.put(gen, key, value, true)
+}
+
+// SetMissing remembers that the code doesn't exist.
+func (c *URLCache) SetMissing(gen uint64, key string) {
+	c.put(gen, key, models.Target{}, false)
+}
+
+func (c *URLCache) put(gen uint64, key string, value models.Target, found bool) {
+	c.Lock()
+	defer c.Unlock()
+	ttl := c.ttl
+	if !found {
+		ttl = c.negativeTTL
+	}
+	if c.size <= 0 || ttl <= 0 || gen != c.generation {
+		return
+	}
+	expires := c.now().Add(ttl)
+	if el, exists := c.items[key]; exists {
+		e := el.Value.(*cacheEntry)
+		e.value, e.found, e.expires = value, found, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, value: value, found: found, expires: expires})
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
+// Configure changes the size and TTLs of a running cache. Entries over the new
+// size are evicted; cached entries keep the expiry they were stored with.
+func (c *URLCache) Configure(size int, ttl time.Duration, negativeTTL time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.size = size
+	c.ttl = ttl
+	c.negativeTTL = negativeTTL
+	for c.order.Len() > 0 && c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
+
+func (c *URLCache) Invalidate(keys ...string) {
+	c.Lock()
+	defer c.Unlock()
+	c.generation++
+	for _, key := range keys {
+		if el, exists := c.items[key]; exists {
+			c.removeElement(el)
+		}
+	}
+}
+
+// Purge drops every cached entry.
+func (c *URLCache) Purge() {
+	c.Lock()
+	defer c.Unlock()
+	c.generation++
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+func (c *URLCache) Stats() CacheStats {
+	c.Lock()
+	size := c.order.Len()
+	c.Unlock()
+	return CacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Size:      size,
+	}
+}
+
+func (c *URLCache) Hits() uint64 {
+	return atomic.LoadUint64(&c.hits)
+}
+
+func (c *URLCache) Misses() uint64 {
+	return atomic.LoadUint64(&c.misses)
+}
+
+func (c *URLCache) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+}
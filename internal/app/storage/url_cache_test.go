@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"github.com/da-semenov/go-short-url/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestURLCache_LRU(t *testing.T) {
+	c := NewURLCache(2, time.Minute, time.Minute)
+	c.Set(c.Generation(), "a", models.Target{OriginalURL: "url_a"})
+	c.Set(c.Generation(), "b", models.Target{OriginalURL: "url_b"})
+	_, _, ok := c.Get("a")
+	assert.True(t, ok)
+	c.Set(c.Generation(), "c", models.Target{OriginalURL: "url_c"})
+
+	_, _, ok = c.Get("b")
+	assert.False(t, ok, "least recently used entry must be evicted")
+	value, found, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.True(t, found)
+	assert.Equal(t, models.Target{OriginalURL: "url_a"}, value)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 2, stats.Size)
+}
+
+func TestURLCache_TTL(t *testing.T) {
+	now := time.Now()
+	c := NewURLCache(10, time.Minute, time.Second)
+	c.now = func() time.Time { return now }
+	c.Set(c.Generation(), "a", models.Target{OriginalURL: "url_a"})
+	c.SetMissing(c.Generation(), "b")
+
+	_, found, ok := c.Get("b")
+	assert.True(t, ok)
+	assert.False(t, found)
+
+	now = now.Add(2 * time.Second)
+	_, _, ok = c.Get("b")
+	assert.False(t, ok, "negative entry must expire after negativeTTL")
+	_, _, ok = c.Get("a")
+	assert.True(t, ok)
+
+	now = now.Add(time.Minute)
+	_, _, ok = c.Get("a")
+	assert.False(t, ok, "entry must expire after ttl")
+}
+
+func TestURLCache_Invalidate(t *testing.T) {
+	c := NewURLCache(10, time.Minute, time.Minute)
+	gen := c.Generation()
+	c.Set(gen, "a", models.Target{OriginalURL: "url_a"})
+	c.Invalidate("a")
+	_, _, ok := c.Get("a")
+	assert.False(t, ok)
+
+	c.Set(gen, "a", models.Target{OriginalURL: "url_a"})
+	_, _, ok = c.Get("a")
+	assert.False(t, ok, "value read before invalidation must not be stored")
+}
+
+func TestURLCache_Configure(t *testing.T) {
+	c := NewURLCache(3, time.Minute, time.Minute)
+	c.Set(c.Generation(), "a", models.Target{OriginalURL: "url_a"})
+	c.Set(c.Generation(), "b", models.Target{OriginalURL: "url_b"})
+	c.Set(c.Generation(), "c", models.Target{OriginalURL: "url_c"})
+
+	c.Configure(1, time.Minute, 0)
+	assert.Equal(t, 1, c.Stats().Size)
+	assert.Equal(t, uint64(2), c.Stats().Evictions)
+	_, _, ok := c.Get("c")
+	assert.True(t, ok, "most recently used entry must be kept")
+
+	c.SetMissing(c.Generation(), "d")
+	_, _, ok = c.Get("d")
+	assert.False(t, ok, "negative entries must not be cached with zero negativeTTL")
+
+	c.Configure(0, time.Minute, time.Minute)
+	c.Set(c.Generation(), "e", models.Target{OriginalURL: "url_e"})
+	assert.Equal(t, 0, c.Stats().Size)
+}
@@ -0,0 +1,47 @@
+package templates
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"path/filepath"
+)
+
+const (
+	Preview      = "preview.html"
+	Interstitial = "interstitial.html"
+	Password     = "password.html"
+	NotActive    = "not_active.html"
+)
+
+//go:embed *.html
+var files embed.FS
+
+// Load parses the embedded page templates. Files in dir with the same name,
+// e.g. preview.html, replace the embedded ones; an empty dir keeps them all.
+func Load(dir string) (*template.Template, error) {
+	t, err := template.ParseFS(files, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return t, nil
+	}
+	overrides, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	if len(overrides) == 0 {
+		return t, nil
+	}
+	t, err = t.ParseFiles(overrides...)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse templates from %s: %w", dir, err)
+	}
+	return t, nil
+}
+
+// Default returns the embedded templates.
+func Default() *template.Template {
+	return template.Must(Load(""))
+}
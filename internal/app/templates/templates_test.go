@@ -0,0 +1,42 @@
+package templates
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, Preview), []byte(`custom {{.Title}}`), 0644))
+
+	tests := []struct {
+		name string
+		dir  string
+		want string
+	}{
+		{name: "Test 1. Embedded.", dir: "", want: "<title>Some &amp; title</title>"},
+		{name: "Test 2. Overridden.", dir: dir, want: "custom Some &amp; title"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Load(tt.dir)
+			assert.NoError(t, err)
+			var buf bytes.Buffer
+			err = tmpl.ExecuteTemplate(&buf, Preview, map[string]interface{}{"Title": "Some & title", "CreatedAt": time.Now()})
+			assert.NoError(t, err)
+			assert.Contains(t, buf.String(), tt.want)
+			assert.NotNil(t, tmpl.Lookup(Interstitial), "templates that are not overridden must be kept")
+		})
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, Preview), []byte(`{{.Title`), 0644))
+	_, err := Load(dir)
+	assert.Error(t, err)
+}
@@ -1,13 +1,119 @@
 package urls
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 type ShortenResponse struct {
 	Result string `json:"result"`
 }
 
+// LinkOptions are the optional per-link settings given when shortening or
+// editing a link.
+type LinkOptions struct {
+	Title string `json:"title,omitempty"`
+	// RedirectType is the HTTP status of the redirect; zero uses the
+	// configured default.
+	RedirectType int `json:"redirect_type,omitempty"`
+	// QueryPassthrough merges the query string of the visit into the
+	// destination.
+	QueryPassthrough bool `json:"query_passthrough,omitempty"`
+	UTM              *UTM `json:"utm,omitempty"`
+	// Password protects the link; only its bcrypt hash is stored. An edit
+	// without it keeps the password of the link, an empty one removes it.
+	Password *string `json:"password,omitempty"`
+	// MaxClicks makes the link expire after that many redirects; zero means
+	// no limit. An edit without it keeps the clicks the link has left, one
+	// with it starts counting anew.
+	MaxClicks *int `json:"max_clicks,omitempty"`
+	// ActiveFrom and ActiveUntil limit when the link redirects; either end
+	// may be left open.
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+	// Rules send visitors to other destinations by device and language; the
+	// first rule that matches wins, the link URL is the fallback.
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// IsZero tells whether no option is set. Only such a plain link shares its
+// code with everyone who shortens the same URL; a link with options gets a
+// code of its own, so nobody else's options end up on it.
+func (o LinkOptions) IsZero() bool {
+	return o.Title == "" && o.RedirectType == 0 && !o.QueryPassthrough && o.UTM == nil && (o.Password == nil || *o.Password == "") &&
+		(o.MaxClicks == nil || *o.MaxClicks == 0) && o.ActiveFrom == nil && o.ActiveUntil == nil && len(o.Rules) == 0
+}
+
+// Rule matches visitors by the platform of their user agent and by the
+// language they prefer most in Accept-Language. A language matches its
+// regional variants too: "pt" matches "pt-BR". Empty conditions match
+// everyone, but a rule needs at least one.
+type Rule struct {
+	Platform string `json:"platform,omitempty"`
+	Language string `json:"language,omitempty"`
+	URL      string `json:"url"`
+}
+
+// Platforms are the user agent platforms a rule may match.
+var Platforms = []string{"ios", "android", "windows", "macos", "linux"}
+
+const (
+	UTMKeep     = "keep"
+	UTMOverride = "override"
+)
+
+// UTM parameters are added to the destination on redirect. When the
+// destination already has one of them, Conflict decides: keep (the default)
+// leaves its value, override replaces it.
+type UTM struct {
+	Source   string `json:"utm_source,omitempty"`
+	Medium   string `json:"utm_medium,omitempty"`
+	Campaign string `json:"utm_campaign,omitempty"`
+	Conflict string `json:"conflict,omitempty"`
+}
+
+// RedirectTypes are the statuses a link may redirect with.
+var RedirectTypes = []int{301, 302, 307, 308}
+
+func ValidRedirectType(status int) bool {
+	for _, t := range RedirectTypes {
+		if t == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Target is where a short link redirects to.
+type Target struct {
+	URL              string
+	RedirectType     int
+	QueryPassthrough bool
+	UTM              *UTM
+	// PasswordHash is set for a password-protected link.
+	PasswordHash string
+	// Limited is set for a link with max_clicks; following it uses up one
+	// of its clicks.
+	Limited bool
+	// ActiveUntil is when the link stops redirecting, if it has such a time.
+	ActiveUntil *time.Time
+	Rules       []Rule
+}
+
+// NotActiveError is returned for a link whose activation window has not
+// opened yet.
+type NotActiveError struct {
+	ActiveFrom time.Time
+}
+
+func (e *NotActiveError) Error() string {
+	return "link is not active until " + e.ActiveFrom.UTC().Format(time.RFC3339)
+}
+
 type ShortenRequest struct {
 	URL string `json:"url"`
+	LinkOptions
 }
 
 type UserURLs struct {
@@ -18,6 +124,7 @@ type UserURLs struct {
 type UserBatch struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	LinkOptions
 }
 
 type UserBatchResult struct {
@@ -25,5 +132,69 @@ type UserBatchResult struct {
 	ShortURL      string `json:"short_url"`
 }
 
+// Link is what the preview page shows about a short link.
+type Link struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	Title       string    `json:"title,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Clicks      int64     `json:"clicks"`
+	// BlockedBy is the blocklist rule the destination matches, if any.
+	BlockedBy    string `json:"blocked_by,omitempty"`
+	PasswordHash string `json:"-"`
+	// ClicksLeft is set for a link with max_clicks.
+	ClicksLeft  *int64     `json:"clicks_left,omitempty"`
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+}
+
+type Stats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+const (
+	QuotaLinks      = "links"
+	QuotaBatchSize  = "batch_size"
+	QuotaDeleteSize = "delete_size"
+)
+
+// QuotaError is returned when a request would go over a per-user limit.
+type QuotaError struct {
+	Quota string `json:"quota"`
+	Usage int    `json:"usage"`
+	Limit int    `json:"limit"`
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s quota exceeded: %d of %d", e.Quota, e.Usage, e.Limit)
+}
+
+// ValidationError tells why a URL or a link option was rejected. An empty
+// Field means the URL.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	field := e.Field
+	if field == "" {
+		field = "url"
+	}
+	return "invalid " + field + ": " + e.Reason
+}
+
+// BlockedError is returned for a URL that matches the blocklist.
+type BlockedError struct {
+	URL  string
+	Rule string
+}
+
+func (e *BlockedError) Error() string {
+	return "url is blocklisted: matches " + e.Rule
+}
+
 var ErrDuplicateKey = errors.New("duplicate key")
 var ErrNotFound = errors.New("no rows in result set")
+var ErrWrongPassword = errors.New("wrong password")